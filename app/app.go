@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -22,21 +23,27 @@ import (
 )
 
 var (
-	recurringCommands  = map[recurring.Frequency][]*RecurringCommand{}
+	scheduler          = newScheduler()
 	errIncorrectSecret = errors.New("not attempting connection, secret seems incorrect")
 )
 
 // Start a Discord session for a given token.
-func Start(secret string, dbPool *pgxpool.Pool, ticker *Timers) (*discordgo.Session, error) {
+// Cancelling the context cancels every command in progress, and stops recurring commands.
+// To stop gracefully instead, use Shutdown.
+func Start(parent context.Context, secret string, dbPool *pgxpool.Pool) (*Bot, error) {
 	if len(secret) == 0 {
 		return nil, errIncorrectSecret
 	}
 
+	ctx, cancel := context.WithCancel(parent)
+
 	commandMap, commandList := discoverCommand(dbPool)
+	registeredCommands = commandMap
 
 	session, err := discordgo.New("Bot " + secret)
 	if err != nil {
 		log.Println("Unable to create Discord session")
+		cancel()
 
 		return nil, err
 	}
@@ -50,32 +57,84 @@ func Start(secret string, dbPool *pgxpool.Pool, ticker *Timers) (*discordgo.Sess
 	audioChannel := make(chan *audio.Data)
 	voiceCommandChannel := make(chan audio.VoiceCommand)
 
-	go waitForAudio(session, audioChannel, messageChannel, voiceCommandChannel)
-	// TODO: If panicking while processing a command, error instead of crashing
+	stopAudio, audioStopped := make(chan struct{}), make(chan struct{})
+
+	go waitForAudio(session, audioChannel, messageChannel, voiceCommandChannel, stopAudio, audioStopped)
 	session.AddHandler(func(s *discordgo.Session, m *discordgo.MessageCreate) {
-		// Ignore messages without the '!' prefix or with own ID
-		if m.Author.ID == s.State.User.ID || !strings.HasPrefix(m.Content, string(Prefix)) {
+		// Ignore messages with own ID, or any sent while shutting down
+		if m.Author.ID == s.State.User.ID || !inFlight.begin() {
+			return
+		}
+		defer inFlight.end()
+
+		defer recoverCommand(m.ChannelID, messageChannel)
+
+		prefix := persistent.LookupPrefix(ctx, dbPool, m.GuildID)
+		// Ignore messages without the server's prefix (or a mention)
+		content, hasPrefix := trimPrefix(m.Content, prefix, s.State.User.ID)
+		if !hasPrefix {
 			return
 		}
-		commandHandler(s, m, dbPool, commandMap, commandList, messageChannel, audioChannel, voiceCommandChannel)
+
+		// Commands parse the message as if it were "<alias> <arguments...>", whatever the prefix was
+		m.Content = content
+		commandHandler(ctx, s, m, prefix, dbPool, commandMap, commandList, messageChannel, audioChannel, voiceCommandChannel)
 	})
 
-	go ticker.Start(recurringCommands, dbPool, session)
+	session.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		if !inFlight.begin() {
+			return
+		}
+		defer inFlight.end()
+
+		interactionHandler(ctx, s, i, dbPool, commandMap, commandList, messageChannel, audioChannel, voiceCommandChannel)
+	})
+	session.AddHandler(func(s *discordgo.Session, r *discordgo.MessageReactionAdd) {
+		confirmations.react(r)
+	})
+	// Ready is sent again after reconnecting, but overwriting the slash commands is idempotent
+	session.AddHandler(func(s *discordgo.Session, r *discordgo.Ready) {
+		registerApplicationCommands(s, r.User.ID, commandMap)
+	})
+
+	scheduler.start(ctx, dbPool, session)
 
 	if err = session.Open(); err != nil {
 		log.Println("Failed to open WebSocket connection to Discord servers")
+		cancel()
 
 		return nil, err
 	}
 
 	log.Println("Opened WebSocket connection to Discord...")
 
-	return session, nil
+	return &Bot{
+		Session:      session,
+		cancel:       cancel,
+		stopAudio:    stopAudio,
+		audioStopped: audioStopped,
+	}, nil
+}
+
+// trimPrefix removes the prefix (or a mention of the bot) from the start of a message.
+// If the message starts with neither, it is not a command and false is returned.
+func trimPrefix(content string, prefix string, botID string) (string, bool) {
+	for _, candidate := range []string{prefix, "<@" + botID + ">", "<@!" + botID + ">"} {
+		if strings.HasPrefix(content, candidate) {
+			trimmed := strings.TrimSpace(strings.TrimPrefix(content, candidate))
+
+			return trimmed, len(trimmed) != 0
+		}
+	}
+
+	return "", false
 }
 
 func commandHandler(
+	ctx context.Context,
 	s *discordgo.Session,
 	m *discordgo.MessageCreate,
+	prefix string,
 	dbPool *pgxpool.Pool,
 	commandMap map[string]*Command,
 	commandList []string,
@@ -88,21 +147,69 @@ func commandHandler(
 
 	log.Printf("Ack %s: %s", m.Author.Username, m.Content)
 
+	// Help and unrecognized commands are replied to, so they are throttled too (cmd is nil for them)
+	if wait := throttle(s, m, cmd); wait > 0 {
+		log.Printf("Throttled %s for %s: %s", m.Author.Username, wait, m.Content)
+		msgChannel <- cooldownResponse(m, wait)
+
+		return
+	}
+
 	if !found {
-		if content[0] == BuildCommandName("help") {
-			msgChannel <- handleHelpMessage(m.ChannelID, commandList, content[1:], commandMap)
+		if content[0] == "help" {
+			msgChannel <- handleHelpMessage(m.ChannelID, prefix, commandList, content[1:], commandMap)
 		} else {
 			log.Printf("Unrecognized command: %s", m.Content)
 			msgChannel <- commands.MessageResponse{
 				ChannelID: m.ChannelID,
-				Message:   fmt.Sprintf("Unrecognized command: `%s`", content[0]),
+				Message:   fmt.Sprintf("Unrecognized command: `%s`", BuildCommandName(prefix, content[0])),
 			}
 		}
 
 		return
 	}
 
-	go processCommand(discordInfo{
+	if commandError := checkPermission(ctx, s, m, dbPool, prefix, content[1:], cmd); commandError != nil {
+		log.Printf("Denied %s: %s", m.Author.Username, m.Content)
+		msgChannel <- commands.MessageResponse{
+			ChannelID: m.ChannelID,
+			Message:   commandError.Error(),
+		}
+
+		return
+	}
+
+	// Commands that act on another channel have to be allowed there too
+	commandError := checkTargetChannel(s, m, content[1:], cmd)
+	if commandError == nil {
+		commandError = checkTargetPermission(ctx, s, m, dbPool, prefix, content[1:], cmd)
+	}
+
+	if commandError != nil {
+		log.Printf("Rejected %s: %s", m.Author.Username, m.Content)
+		msgChannel <- commands.MessageResponse{
+			ChannelID: m.ChannelID,
+			Message:   commandError.Error(),
+		}
+
+		return
+	}
+
+	privilegedCmd, isPrivileged := (*cmd).(PrivilegedCommand)
+	if isPrivileged && privilegedCmd.Privileged(content[1:]) && !canManageServer(s, m) {
+		log.Printf("Denied %s: %s", m.Author.Username, m.Content)
+		msgChannel <- commands.MessageResponse{
+			ChannelID: m.ChannelID,
+			Message: fmt.Sprintf("Only users who can manage this server can do that with `%s`",
+				BuildCommandName(prefix, content[0]),
+			),
+		}
+
+		return
+	}
+
+	// Handlers are already run in their own goroutine, so the command can be processed synchronously
+	processCommand(ctx, discordInfo{
 		session: s,
 		message: m,
 	}, msgInfo{
@@ -119,6 +226,9 @@ func discoverCommand(dbPool *pgxpool.Pool) (map[string]*Command, []string) {
 	commandMap := make(map[string]*Command)
 
 	for _, cmd := range []interface{}{
+		JobCleanup{},
+		Jobs{},
+		Perms{},
 		animal.Bird{},
 		animal.Cat{},
 		animal.Dog{},
@@ -131,8 +241,12 @@ func discoverCommand(dbPool *pgxpool.Pool) (map[string]*Command, []string) {
 		noargs.License{},
 		noargs.Source{},
 		persistent.Filter{},
+		persistent.Prefix{},
 		persistent.RSS{},
 		persistent.Sub{},
+		persistent.Unsub{},
+		persistent.Watch{},
+		recurring.DigestPost{},
 		recurring.SubCheck{},
 		recurring.SubCleanup{},
 		simple.Choose{},
@@ -147,14 +261,12 @@ func discoverCommand(dbPool *pgxpool.Pool) (map[string]*Command, []string) {
 		command, ok := cmd.(Command)
 		if ok && isValidCommand(&command, dbPool) {
 			for _, alias := range command.CommandList() {
-				commandMap[BuildCommandName(alias)] = &command
+				commandMap[alias] = &command
 			}
 		} else {
 			recurringCmd, isRecurring := cmd.(RecurringCommand)
 			if isRecurring {
-				freq := recurringCmd.Frequency()
-				recurringCommands[freq] = append(recurringCommands[freq], &recurringCmd)
-				log.Printf("Registered recurring %v command: %s", recurringCmd.Frequency(), reflect.TypeOf(recurringCmd).Name())
+				scheduler.add(&recurringCmd)
 			}
 		}
 	}
@@ -212,22 +324,39 @@ func isValidCommand(command *Command, dbPool *pgxpool.Pool) bool {
 
 func handleHelpMessage(
 	channelID string,
+	prefix string,
 	commandList []string,
 	args []string,
 	commandMap map[string]*Command,
 ) commands.MessageResponse {
 	helpMsg := fmt.Sprintf("Available commands (All require prefix `%s`):\n`%s`,"+
-		"(For more information on a specific command: `help <command name>`)",
-		string(Prefix),
+		"(For more information on a specific command: `%s <command name>`)",
+		prefix,
 		strings.Join(commandList, "`, `"),
+		BuildCommandName(prefix, "help"),
 	)
 
 	if len(args) != 0 {
-		cmd, ok := commandMap[BuildCommandName(args[1])]
+		alias := strings.TrimPrefix(args[0], prefix)
+
+		cmd, ok := commandMap[alias]
 		if !ok {
-			helpMsg = fmt.Sprintf("Cannot find help message, command `%s` does not exist", BuildCommandName(args[1]))
+			helpMsg = fmt.Sprintf("Cannot find help message, command `%s` does not exist", BuildCommandName(prefix, alias))
 		} else {
 			helpMsg = (*cmd).Help()
+			if permissionedCmd, isPermissioned := (*cmd).(PermissionedCommand); isPermissioned {
+				requires := "Requires"
+				if _, isPartly := (*cmd).(PartlyPermissionedCommand); isPartly {
+					requires = "Changes require"
+				}
+
+				helpMsg += fmt.Sprintf("\n_%s the %s permission, unless changed with `%s`_",
+					requires, permissionName(permissionedCmd.Permission()), BuildCommandName(prefix, "perms"))
+			}
+
+			if cooldownCmd, hasCooldown := (*cmd).(CooldownCommand); hasCooldown {
+				helpMsg += fmt.Sprintf("\n_Each user can only use this once every %s_", cooldownCmd.Cooldown())
+			}
 		}
 	}
 
@@ -237,36 +366,167 @@ func handleHelpMessage(
 	}
 }
 
+// canManageServer reports whether the author of a message has the Administrator or Manage Server permission.
+func canManageServer(s *discordgo.Session, m *discordgo.MessageCreate) bool {
+	permissions, err := memberPermissions(s, m)
+	if err != nil {
+		handler.LogErrorMsg("Failed to look up permissions", err)
+
+		return false
+	}
+
+	return permissions&adminPermissions != 0
+}
+
 func waitForCommandResponses(session *discordgo.Session, messageChannel <-chan commands.MessageResponse) {
 	for pendingMsg := range messageChannel {
-		if len(pendingMsg.Reaction.MessageID) != 0 {
-			if len(pendingMsg.Reaction.Add) != 0 {
-				handler.LogErrorMsg(
-					fmt.Sprintf("Failed to add reaction %s", pendingMsg.Reaction.Add),
-					session.MessageReactionAdd(
-						pendingMsg.ChannelID,
-						pendingMsg.Reaction.MessageID,
-						pendingMsg.Reaction.Add,
-					),
-				)
-			}
+		sendResponse(session, pendingMsg)
+	}
+}
 
-			if len(pendingMsg.Reaction.Remove) != 0 {
-				handler.LogErrorMsg(
-					fmt.Sprintf("Failed to remove reaction %s", pendingMsg.Reaction.Remove),
-					session.MessageReactionRemove(
-						pendingMsg.ChannelID,
-						pendingMsg.Reaction.MessageID,
-						pendingMsg.Reaction.Remove,
-						session.State.User.ID,
-					),
-				)
-			}
+// sendResponse adds or removes reactions and sends the message (if any), reporting whether a message was sent.
+func sendResponse(session *discordgo.Session, pendingMsg commands.MessageResponse) bool {
+	sent := postResponse(session, pendingMsg)
+
+	if pendingMsg.Delivered != nil {
+		pendingMsg.Delivered(sent)
+	}
+
+	return sent
+}
+
+func postResponse(session *discordgo.Session, pendingMsg commands.MessageResponse) bool {
+	if len(pendingMsg.GuildID) != 0 && !channelInGuild(session, pendingMsg.ChannelID, pendingMsg.GuildID) {
+		log.Printf("Not sending to %s, it isn't in %s", pendingMsg.ChannelID, pendingMsg.GuildID)
+
+		return false
+	}
+
+	if len(pendingMsg.Reaction.MessageID) != 0 {
+		if len(pendingMsg.Reaction.Add) != 0 {
+			handler.LogErrorMsg(
+				fmt.Sprintf("Failed to add reaction %s", pendingMsg.Reaction.Add),
+				session.MessageReactionAdd(
+					pendingMsg.ChannelID,
+					pendingMsg.Reaction.MessageID,
+					pendingMsg.Reaction.Add,
+				),
+			)
 		}
 
-		if len(pendingMsg.Message) != 0 {
-			_, err := session.ChannelMessageSend(pendingMsg.ChannelID, pendingMsg.Message)
-			handler.LogError(err)
+		if len(pendingMsg.Reaction.Remove) != 0 {
+			handler.LogErrorMsg(
+				fmt.Sprintf("Failed to remove reaction %s", pendingMsg.Reaction.Remove),
+				session.MessageReactionRemove(
+					pendingMsg.ChannelID,
+					pendingMsg.Reaction.MessageID,
+					pendingMsg.Reaction.Remove,
+					session.State.User.ID,
+				),
+			)
 		}
 	}
+
+	if len(pendingMsg.Message) == 0 && pendingMsg.Embed == nil && pendingMsg.File == nil {
+		return false
+	}
+
+	var sent *discordgo.Message
+
+	var err error
+
+	switch {
+	case pendingMsg.Interaction != nil:
+		params := &discordgo.WebhookParams{Content: pendingMsg.Message, AllowedMentions: pendingMsg.AllowedMentions}
+		if pendingMsg.Embed != nil {
+			params.Embeds = []*discordgo.MessageEmbed{pendingMsg.Embed}
+		}
+
+		if pendingMsg.File != nil {
+			params.Files = []*discordgo.File{pendingMsg.File}
+		}
+
+		sent, err = session.FollowupMessageCreate(session.State.User.ID, pendingMsg.Interaction, true, params)
+	case pendingMsg.Embed != nil || pendingMsg.File != nil || pendingMsg.AllowedMentions != nil:
+		send := &discordgo.MessageSend{Content: pendingMsg.Message, AllowedMentions: pendingMsg.AllowedMentions}
+		if pendingMsg.Embed != nil {
+			send.Embeds = []*discordgo.MessageEmbed{pendingMsg.Embed}
+		}
+
+		if pendingMsg.File != nil {
+			send.Files = []*discordgo.File{pendingMsg.File}
+		}
+
+		sent, err = session.ChannelMessageSendComplex(pendingMsg.ChannelID, send)
+	default:
+		sent, err = session.ChannelMessageSend(pendingMsg.ChannelID, pendingMsg.Message)
+	}
+
+	handler.LogError(err)
+
+	if pendingMsg.Confirmation != nil {
+		if err != nil {
+			pendingMsg.Confirmation.Resolve(commands.Cancelled)
+
+			return false
+		}
+
+		confirmations.await(session, pendingMsg.ChannelID, sent.ID, pendingMsg.Confirmation)
+	}
+
+	return err == nil
+}
+
+// channelInGuild reports whether a channel belongs to a server.
+func channelInGuild(session *discordgo.Session, channelID string, guildID string) bool {
+	channel, err := findChannel(session, channelID)
+	if err != nil {
+		handler.LogErrorMsg(fmt.Sprintf("Failed to look up channel %s", channelID), err)
+
+		return false
+	}
+
+	return channel.GuildID == guildID
+}
+
+// findChannel looks up a channel, preferring the cached state over asking Discord.
+func findChannel(session *discordgo.Session, channelID string) (*discordgo.Channel, error) {
+	channel, err := session.State.Channel(channelID)
+	if err != nil {
+		return session.Channel(channelID)
+	}
+
+	return channel, nil
+}
+
+// checkTargetChannel reports why the command can't act on the channel its arguments name, or nil if it can.
+func checkTargetChannel(
+	s *discordgo.Session,
+	m *discordgo.MessageCreate,
+	args []string,
+	cmd *Command,
+) *commands.CommandError {
+	channelCmd, isChannelCmd := (*cmd).(ChannelCommand)
+	if !isChannelCmd || len(m.GuildID) == 0 {
+		return nil
+	}
+
+	channelID, mustExist := channelCmd.TargetChannel(args)
+	if len(channelID) == 0 || channelID == m.ChannelID {
+		return nil
+	}
+
+	channel, err := findChannel(s, channelID)
+
+	switch {
+	case err != nil && !mustExist:
+		return nil
+	case err != nil || channel.GuildID != m.GuildID:
+		return commands.NewError(fmt.Sprintf("I can't find a channel `%s` in this server, mention one like #general",
+			channelID))
+	case mustExist && channel.Type != discordgo.ChannelTypeGuildText && channel.Type != discordgo.ChannelTypeGuildNews:
+		return commands.NewError(fmt.Sprintf("<#%s> isn't a text channel, so nothing can be posted to it", channelID))
+	}
+
+	return nil
 }
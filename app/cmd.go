@@ -1,7 +1,9 @@
 package app
 
 import (
+	"context"
 	"strings"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/jackc/pgx/v4/pgxpool"
@@ -10,9 +12,6 @@ import (
 	"quozlet.net/birbbot/app/commands/recurring"
 )
 
-// Prefix that all commands must begin with to be recognized.
-const Prefix = '!'
-
 // Command is an interface that must be implemented for commands.
 type Command interface {
 	// CommandList returns all aliases for the given command (must return at least one)
@@ -21,12 +20,18 @@ type Command interface {
 	Help() string
 }
 
+// ArgumentCommand is a command that describes the arguments it accepts.
+type ArgumentCommand interface {
+	// Arguments returns the arguments accepted by the command, in the order they're expected
+	Arguments() []commands.Argument
+}
+
 // SimpleCommand is a command that responds to a message with no other context.
 type SimpleCommand interface {
 	// Check asserts all preconditions are met, and returns an error if they are not
 	Check() error
 	// ProcessMessage processes all additional arguments to the command (split on whitespace)
-	ProcessMessage(chan<- commands.MessageResponse, *discordgo.MessageCreate) *commands.CommandError
+	ProcessMessage(context.Context, chan<- commands.MessageResponse, *discordgo.MessageCreate) *commands.CommandError
 }
 
 // PersistentCommand is a command that will persist some data into a database.
@@ -34,7 +39,12 @@ type PersistentCommand interface {
 	// Check asserts all preconditions are met, and returns an error if they are not
 	Check(*pgxpool.Pool) error
 	// ProcessMessage processes all additional arguments to the command (split on whitespace)
-	ProcessMessage(chan<- commands.MessageResponse, *discordgo.MessageCreate, *pgxpool.Pool) *commands.CommandError
+	ProcessMessage(
+		context.Context,
+		chan<- commands.MessageResponse,
+		*discordgo.MessageCreate,
+		*pgxpool.Pool,
+	) *commands.CommandError
 }
 
 // AudioCommand is a command that will return an Opus stream for a channel.
@@ -45,28 +55,83 @@ type AudioCommand interface {
 	) (*audio.Data, *commands.CommandError)
 }
 
+// PlayableCommand is a command where some invocations return audio to play, instead of being processed as usual.
+type PlayableCommand interface {
+	// Playable reports whether the arguments to the command (split on whitespace) return audio to play
+	Playable([]string) bool
+	// ProcessAudio processes a playable invocation of the command, returning the audio to play
+	ProcessAudio(
+		context.Context,
+		chan<- commands.MessageResponse,
+		*discordgo.MessageCreate,
+		*pgxpool.Pool,
+	) (*audio.Data, *commands.CommandError)
+}
+
 // NoArgsCommand will always go through the same flow to response, irrespective of arguments.
 type NoArgsCommand interface {
 	// Check asserts all preconditions are met, and returns an error if they are not
 	Check() error
 	// ProcessMessage returns the response
-	ProcessMessage() ([]string, *commands.CommandError)
+	ProcessMessage(context.Context) ([]string, *commands.CommandError)
+}
+
+// PrivilegedCommand is a command where some invocations require the user to be able to manage the server.
+type PrivilegedCommand interface {
+	// Privileged reports whether the arguments to the command (split on whitespace) require elevated permissions
+	Privileged([]string) bool
+}
+
+// ChannelCommand is a command whose arguments can name another channel than the one it was sent in, e.g. to post into.
+// The channel has to be a text channel in the same server.
+type ChannelCommand interface {
+	// TargetChannel returns the channel the arguments (split on whitespace) name, or an empty string if they don't.
+	// Unless the channel must exist, a channel that can't be found (e.g. because it was deleted) is still named
+	TargetChannel([]string) (channelID string, mustExist bool)
+}
+
+// PermissionedCommand is a command that by default requires a Discord permission, unless a server overrides it.
+// Commands that don't declare a permission can be run by anyone, unless a server overrides that too.
+type PermissionedCommand interface {
+	// Permission returns the permission required, e.g. discordgo.PermissionManageChannels
+	Permission() int64
+}
+
+// PartlyPermissionedCommand is a PermissionedCommand where only some invocations require its permission by default,
+// e.g. anyone can list what's there, but changing it requires the permission.
+type PartlyPermissionedCommand interface {
+	// Permissioned reports whether the arguments to the command (split on whitespace) require its permission
+	Permissioned([]string) bool
 }
 
-// RecurringCommand will be run on a recurring basis, and return a map of channels to messages to post
+// CooldownCommand is a command each user has to wait between uses of, e.g. because it calls a rate limited API.
+// Every command is also throttled per user, channel and server, this is on top of that.
+type CooldownCommand interface {
+	// Cooldown returns how long a user has to wait after using the command before using it again
+	Cooldown() time.Duration
+}
+
+// RecurringCommand will be run on a recurring basis, and return messages to post
 // Note: It is not explicitly invoked, and some other command should handle populating data for it.
 type RecurringCommand interface {
-	// Check will check if there is any update. If an error occurs or there is no update, return nil
-	Check(*pgxpool.Pool) map[string][]string
-	// Frequency reports the preferred frequency for this command
-	Frequency() recurring.Frequency
+	// Check will check if there is any update, returning nil if there is none.
+	// An error is recorded in the run history, but any messages returned alongside it are still posted
+	Check(context.Context, *pgxpool.Pool) ([]commands.MessageResponse, error)
+	// Schedule reports when this command should run. A recurring.Frequency is the simplest Schedule
+	Schedule() recurring.Schedule
+}
+
+// TimedCommand is a command (or RecurringCommand) that needs a different deadline than the default.
+type TimedCommand interface {
+	// Timeout returns how long the command may run before its context is cancelled
+	Timeout() time.Duration
 }
 
-// BuildCommandName is a helper function to efficiently concatenate the current prefix with a command name.
-func BuildCommandName(alias string) string {
+// BuildCommandName is a helper function to efficiently concatenate a prefix with a command name.
+func BuildCommandName(prefix string, alias string) string {
 	var builder strings.Builder
 
-	builder.WriteRune(Prefix)
+	builder.WriteString(prefix)
 	builder.WriteString(alias)
 
 	return builder.String()
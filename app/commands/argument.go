@@ -0,0 +1,12 @@
+package commands
+
+// Argument describes a single argument a command accepts.
+// Arguments are joined (in order, split on whitespace) as if they were typed after the command.
+type Argument struct {
+	// Name of the argument, which must be lowercase and contain no spaces
+	Name string
+	// Description shown to the user when filling in the argument
+	Description string
+	// Required arguments must be provided for the command to be invoked
+	Required bool
+}
@@ -90,6 +90,19 @@ func (d Data) Cleanup() error {
 	return nil
 }
 
+// RemoveCache removes the file the audio was cached as, if it was cached.
+// Unlike Cleanup, it must not be called once the audio source has been fetched.
+func (d Data) RemoveCache() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.audio.session == nil && len(d.audio.filename) != 0 {
+		return os.Remove(d.audio.filename)
+	}
+
+	return nil
+}
+
 // VoiceCommand indicates a special handling command besides playing audio.
 type VoiceCommand int
 
@@ -29,6 +29,12 @@ func (d Disconnect) ProcessMessage(
 	return nil, nil
 }
 
+// Permission reports the permission the Disconnect Command requires by default.
+// Disconnecting stops audio for everyone listening, so it requires being able to move members out of voice.
+func (d Disconnect) Permission() int64 {
+	return discordgo.PermissionVoiceMoveMembers
+}
+
 // CommandList returns the list of aliases for the Disconnect Command.
 func (d Disconnect) CommandList() []string {
 	return []string{"disconnect", "dc"}
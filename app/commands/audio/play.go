@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/jonas747/dca"
@@ -50,6 +51,12 @@ func (p Play) ProcessMessage(
 	return nil, commands.CreateCommandError("Unrecognized format, can't enqueue to play", err)
 }
 
+// Cooldown reports how long each user waits between uses of the Play Command.
+// Every URL played starts another ffmpeg process to encode it.
+func (p Play) Cooldown() time.Duration {
+	return 5 * time.Second
+}
+
 // CommandList returns the list of aliases for the Play Command.
 func (p Play) CommandList() []string {
 	return []string{"play", "p"}
@@ -62,6 +69,17 @@ func (p Play) Help() string {
 		"- `p`/`play` <audio URL> <title> will enqueue that URL with a title"
 }
 
+// PlayURL enqueues the audio at a URL, announcing it with the given title.
+// It is how other commands hand audio they found to be played.
+func PlayURL(
+	url *url.URL,
+	response chan<- commands.MessageResponse,
+	channelID string,
+	title string,
+) (*Data, *commands.CommandError) {
+	return playFromURL(url, response, channelID, strings.Fields(title))
+}
+
 func playFromURL(
 	url *url.URL,
 	response chan<- commands.MessageResponse,
@@ -0,0 +1,101 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	// ConfirmEmoji is the reaction that confirms a Confirmation.
+	ConfirmEmoji = "✅"
+	// CancelEmoji is the reaction that cancels a Confirmation.
+	CancelEmoji = "❌"
+	// Cancelled is the choice of a user who cancelled, or didn't react in time.
+	Cancelled = -1
+)
+
+// ChoiceEmojis are the reactions used to pick one of several choices, in order.
+var ChoiceEmojis = []string{"1️⃣", "2️⃣", "3️⃣", "4️⃣", "5️⃣", "6️⃣", "7️⃣", "8️⃣", "9️⃣"}
+
+// Confirmation asks a single user to pick one of the choices (or cancel), by reacting to the message it was sent with.
+type Confirmation struct {
+	// UserID is the only user whose reactions count
+	UserID string
+	// Choices are the reactions that can be picked, besides the CancelEmoji
+	Choices []string
+	result  chan int
+}
+
+// Resolve reports which of the choices the user picked (or Cancelled). Only the first call has any effect.
+func (c *Confirmation) Resolve(choice int) {
+	select {
+	case c.result <- choice:
+	default:
+	}
+}
+
+// Confirm sends the prompt, and waits for the user to react to it.
+// It reports false if the user cancelled, didn't react in time, or the context was cancelled.
+func Confirm(
+	ctx context.Context,
+	response chan<- MessageResponse,
+	channelID string,
+	userID string,
+	prompt string,
+) bool {
+	return ask(ctx, response, channelID, userID, fmt.Sprintf("%s\nReact with %s to confirm, or %s to cancel",
+		prompt,
+		ConfirmEmoji,
+		CancelEmoji,
+	), []string{ConfirmEmoji}) == 0
+}
+
+// Choose lists the options, and waits for the user to react with the number of one of them.
+// Only as many options as there are ChoiceEmojis can be offered.
+// It returns the index of the option, or Cancelled if the user cancelled, didn't react in time,
+// or the context was cancelled.
+func Choose(
+	ctx context.Context,
+	response chan<- MessageResponse,
+	channelID string,
+	userID string,
+	prompt string,
+	options []string,
+) int {
+	if len(options) > len(ChoiceEmojis) {
+		options = options[:len(ChoiceEmojis)]
+	}
+
+	message := prompt
+	for i, option := range options {
+		message += fmt.Sprintf("\n%s %s", ChoiceEmojis[i], option)
+	}
+
+	return ask(ctx, response, channelID, userID,
+		fmt.Sprintf("%s\nReact with the number of your choice, or %s to cancel", message, CancelEmoji),
+		ChoiceEmojis[:len(options)],
+	)
+}
+
+func ask(
+	ctx context.Context,
+	response chan<- MessageResponse,
+	channelID string,
+	userID string,
+	message string,
+	choices []string,
+) int {
+	confirmation := &Confirmation{UserID: userID, Choices: choices, result: make(chan int, 1)}
+	response <- MessageResponse{
+		ChannelID:    channelID,
+		Message:      message,
+		Confirmation: confirmation,
+	}
+
+	select {
+	case choice := <-confirmation.result:
+		return choice
+	case <-ctx.Done():
+		return Cancelled
+	}
+}
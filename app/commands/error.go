@@ -1,7 +1,11 @@
 package commands
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"log"
+	"runtime/debug"
 )
 
 // CommandError contains user-facing information about an error that occurred processing a command.
@@ -29,3 +33,18 @@ func CreateCommandError(msg string, err error) *CommandError {
 
 	return nil
 }
+
+// RecoverError creates a CommandError for a value recovered from a panic.
+// The stack trace is logged with a correlation ID, which is included in the message so the failure can be found later.
+// It must be called from the deferred function that recovered, otherwise the stack trace will not include the panic.
+func RecoverError(recovered interface{}) *CommandError {
+	correlationID := make([]byte, 4)
+	if _, err := rand.Read(correlationID); err != nil {
+		log.Println(err)
+	}
+
+	log.Printf("Recovered from panic [%s]: %v\n%s", hex.EncodeToString(correlationID), recovered, debug.Stack())
+
+	return NewError(fmt.Sprintf("Something went very wrong processing that, sorry! "+
+		"If you report this, mention `%s`", hex.EncodeToString(correlationID)))
+}
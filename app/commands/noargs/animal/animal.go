@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"time"
 
 	handler "quozlet.net/birbbot/util"
 
@@ -17,6 +18,9 @@ const (
 	catURL  = "https://shibe.online/api/cats"
 )
 
+// Every animal comes from shibe.online, which is a free API that shouldn't be hammered.
+const animalCooldown = 5 * time.Second
+
 func fetchAnimal(ctx context.Context, url string) ([]string, *commands.CommandError) {
 	var commandError *commands.CommandError
 
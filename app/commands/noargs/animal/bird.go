@@ -3,6 +3,7 @@ package animal
 import (
 	"context"
 	"net/url"
+	"time"
 
 	"quozlet.net/birbbot/app/commands"
 )
@@ -18,8 +19,13 @@ func (b Bird) Check() error {
 }
 
 // ProcessMessage for a Bird Command (will return the URL for a random bird image).
-func (b Bird) ProcessMessage() ([]string, *commands.CommandError) {
-	return fetchAnimal(context.Background(), birdURL)
+func (b Bird) ProcessMessage(ctx context.Context) ([]string, *commands.CommandError) {
+	return fetchAnimal(ctx, birdURL)
+}
+
+// Cooldown reports how long each user waits between uses of the Bird Command.
+func (b Bird) Cooldown() time.Duration {
+	return animalCooldown
 }
 
 // CommandList returns applicable aliases for the Bird Command.
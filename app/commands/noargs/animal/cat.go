@@ -3,6 +3,7 @@ package animal
 import (
 	"context"
 	"net/url"
+	"time"
 
 	"quozlet.net/birbbot/app/commands"
 )
@@ -18,8 +19,13 @@ func (c Cat) Check() error {
 }
 
 // ProcessMessage for a Cat Command (will return the URL for a random cat image).
-func (c Cat) ProcessMessage() ([]string, *commands.CommandError) {
-	return fetchAnimal(context.Background(), catURL)
+func (c Cat) ProcessMessage(ctx context.Context) ([]string, *commands.CommandError) {
+	return fetchAnimal(ctx, catURL)
+}
+
+// Cooldown reports how long each user waits between uses of the Cat Command.
+func (c Cat) Cooldown() time.Duration {
+	return animalCooldown
 }
 
 // CommandList returns applicable aliases for Cat Command.
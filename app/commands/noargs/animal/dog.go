@@ -3,6 +3,7 @@ package animal
 import (
 	"context"
 	"net/url"
+	"time"
 
 	"quozlet.net/birbbot/app/commands"
 )
@@ -18,8 +19,13 @@ func (d Dog) Check() error {
 }
 
 // ProcessMessage for a Dog Command (will return the URL for a random dog (specifically shibe) image).
-func (d Dog) ProcessMessage() ([]string, *commands.CommandError) {
-	return fetchAnimal(context.Background(), dogURL)
+func (d Dog) ProcessMessage(ctx context.Context) ([]string, *commands.CommandError) {
+	return fetchAnimal(ctx, dogURL)
+}
+
+// Cooldown reports how long each user waits between uses of the Dog Command.
+func (d Dog) Cooldown() time.Duration {
+	return animalCooldown
 }
 
 // CommandList returns applicable aliases for Dog Command.
@@ -1,6 +1,7 @@
 package noargs
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -44,8 +45,8 @@ func (f Fortune) Check() error {
 }
 
 // ProcessMessage returns a random cow saying a random message. The provided arguments are ignored.
-func (f Fortune) ProcessMessage() ([]string, *commands.CommandError) {
-	fortune, err := exec.Command("fortune", "-a").Output()
+func (f Fortune) ProcessMessage(ctx context.Context) ([]string, *commands.CommandError) {
+	fortune, err := exec.CommandContext(ctx, "fortune", "-a").Output()
 	if err != nil {
 		log.Println(err)
 
@@ -53,7 +54,7 @@ func (f Fortune) ProcessMessage() ([]string, *commands.CommandError) {
 	}
 	// OK to run user provided input
 	/* #nosec */
-	cowsay, cowsayErr := exec.Command("cowsay", "-f", cows[rand.Intn(len(cows))], string(fortune)).Output()
+	cowsay, cowsayErr := exec.CommandContext(ctx, "cowsay", "-f", cows[rand.Intn(len(cows))], string(fortune)).Output()
 	if cowsayErr != nil {
 		log.Println(cowsayErr)
 
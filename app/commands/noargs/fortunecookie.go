@@ -1,6 +1,7 @@
 package noargs
 
 import (
+	"context"
 	"errors"
 	"log"
 	"os/exec"
@@ -26,8 +27,8 @@ func (fc FortuneCookie) Check() error {
 }
 
 // ProcessMessage returns a random fortune.
-func (fc FortuneCookie) ProcessMessage() ([]string, *commands.CommandError) {
-	fortune, err := exec.Command("fortune", "-a").Output()
+func (fc FortuneCookie) ProcessMessage(ctx context.Context) ([]string, *commands.CommandError) {
+	fortune, err := exec.CommandContext(ctx, "fortune", "-a").Output()
 	if err != nil {
 		log.Println(err)
 
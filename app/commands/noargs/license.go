@@ -1,6 +1,8 @@
 package noargs
 
 import (
+	"context"
+
 	"quozlet.net/birbbot/app/commands"
 )
 
@@ -13,7 +15,7 @@ func (l License) Check() error {
 }
 
 // ProcessMessage returns the link to the license for the source code of this bot.
-func (l License) ProcessMessage() ([]string, *commands.CommandError) {
+func (l License) ProcessMessage(_ context.Context) ([]string, *commands.CommandError) {
 	return []string{"This bot's source code is licensed under" +
 		" The Open Software License 3.0 (https://spdx.org/licenses/OSL-3.0.html)"}, nil
 }
@@ -1,6 +1,8 @@
 package noargs
 
 import (
+	"context"
+
 	"quozlet.net/birbbot/app/commands"
 )
 
@@ -13,7 +15,7 @@ func (s Source) Check() error {
 }
 
 // ProcessMessage returns the link to the source code of this bot.
-func (s Source) ProcessMessage() ([]string, *commands.CommandError) {
+func (s Source) ProcessMessage(_ context.Context) ([]string, *commands.CommandError) {
 	return []string{"https://github.com/Quozlet/BirbBot"}, nil
 }
 
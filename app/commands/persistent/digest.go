@@ -0,0 +1,155 @@
+package persistent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"quozlet.net/birbbot/app/commands"
+)
+
+// How a subscription delivers new items.
+const (
+	// ImmediateDelivery posts each new item as soon as it is found.
+	ImmediateDelivery = "immediate"
+	// HourlyDelivery posts the new items together at the start of every hour.
+	HourlyDelivery = "hourly"
+	// DailyDelivery posts the new items together once a day, at the subscription's local time.
+	DailyDelivery = "daily"
+)
+
+const digestInsert string = "INSERT INTO DigestItems (SubscriptionID, Title, Link) " +
+	"SELECT $1, unnest($2::TEXT[]), unnest($3::TEXT[])"
+
+// DigestItem is an item waiting to be posted in a digest.
+type DigestItem struct {
+	Title string
+	Link  string
+}
+
+// BufferDigest keeps the items until the subscription's next digest, and records them as seen by it.
+// Both happen together, so items are never buffered twice, nor seen without being buffered.
+func BufferDigest(
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	subscriptionID, feedID int64,
+	channel string,
+	items []RSSInfo,
+) error {
+	titles := make([]string, 0, len(items))
+	links := make([]string, 0, len(items))
+
+	for _, item := range items {
+		titles = append(titles, item.Title)
+		links = append(links, item.Description)
+	}
+
+	transaction, err := dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	// Rolling back after committing does nothing
+	defer func() {
+		if err := transaction.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			log.Printf("Failed to roll back the digest of %d: %s", subscriptionID, err)
+		}
+	}()
+
+	if _, err := transaction.Exec(ctx, digestInsert, subscriptionID, titles, links); err != nil {
+		return err
+	}
+
+	if _, err := transaction.Exec(ctx, seenInsert, feedID, channel, seenKeys(items)); err != nil {
+		return err
+	}
+
+	return transaction.Commit(ctx)
+}
+
+// NextDigest is when the digest after now should be posted, or nil if items are posted immediately.
+// minute is the minute of the day (in the location) that daily digests are posted at.
+func NextDigest(delivery string, minute int, location *time.Location, now time.Time) *time.Time {
+	var next time.Time
+
+	switch delivery {
+	case HourlyDelivery:
+		next = now.Truncate(time.Hour).Add(time.Hour)
+	case DailyDelivery:
+		local := now.In(location)
+		next = time.Date(local.Year(), local.Month(), local.Day(), minute/60, minute%60, 0, 0, location)
+
+		if !next.After(now) {
+			next = time.Date(local.Year(), local.Month(), local.Day()+1, minute/60, minute%60, 0, 0, location)
+		}
+	default:
+		return nil
+	}
+
+	return &next
+}
+
+// RenderDigest builds a single message listing every item in the digest, in the given style.
+// Items that don't fit are counted instead.
+func RenderDigest(feedTitle string, items []DigestItem, style string) commands.MessageResponse {
+	heading := fmt.Sprintf("%d new items", len(items))
+	if len(items) == 1 {
+		heading = "1 new item"
+	}
+
+	if style == TextStyle {
+		lines := make([]string, 0, len(items))
+		for _, item := range items {
+			lines = append(lines, fmt.Sprintf("- %s <%s>", truncate(item.Title, embedTitleLength), item.Link))
+		}
+
+		return commands.MessageResponse{
+			Message: fitLines(fmt.Sprintf("**%s** (%s)", feedTitle, heading), lines, maxMessageLength),
+		}
+	}
+
+	lines := make([]string, 0, len(items))
+	for _, item := range items {
+		if strings.HasPrefix(item.Link, "http") {
+			lines = append(lines, fmt.Sprintf("• [%s](%s)", truncate(item.Title, embedTitleLength), item.Link))
+		} else {
+			lines = append(lines, fmt.Sprintf("• %s", truncate(item.Link, embedSummaryLength)))
+		}
+	}
+
+	return commands.MessageResponse{Embed: &discordgo.MessageEmbed{
+		Author:      &discordgo.MessageEmbedAuthor{Name: truncate(feedTitle, embedTitleLength)},
+		Title:       heading,
+		Description: fitLines("", lines, embedDescriptionLength),
+	}}
+}
+
+// fitLines joins as many of the lines as fit after the heading, followed by how many didn't fit.
+func fitLines(heading string, lines []string, length int) string {
+	// Enough room is always left to say how many more there are
+	reserved := len("\n…and 99999 more")
+
+	builder := strings.Builder{}
+	builder.WriteString(heading)
+
+	for i, line := range lines {
+		if builder.Len()+len(line)+1 > length-reserved {
+			builder.WriteString(fmt.Sprintf("\n…and %d more", len(lines)-i))
+
+			break
+		}
+
+		if builder.Len() != 0 {
+			builder.WriteString("\n")
+		}
+
+		builder.WriteString(line)
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,183 @@
+package persistent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+	"quozlet.net/birbbot/app/commands"
+)
+
+// feedLinkTypes are the types of <link rel="alternate"> that point to feeds.
+var feedLinkTypes = map[string]struct{}{
+	"application/rss+xml":   {},
+	"application/atom+xml":  {},
+	"application/feed+json": {},
+}
+
+var (
+	errInvalidFeedURL     = errors.New("not an http(s) URL")
+	errNoFeedLinks        = errors.New("not a feed, and doesn't link to any")
+	errDiscoveryCancelled = errors.New("none of the linked feeds were picked")
+)
+
+// feedLink is a feed that a page links to.
+type feedLink struct {
+	title string
+	url   string
+}
+
+// chooseFeed picks one of the feeds a page links to, or returns commands.Cancelled.
+type chooseFeed func([]feedLink) int
+
+// firstFeed picks the first feed a page links to, since it's usually the main one.
+func firstFeed([]feedLink) int {
+	return 0
+}
+
+// openFeed fetches the feed at the URL, or the feed linked to by the page at the URL.
+// The URL is upgraded to https if it can be, but http is used if https is unavailable.
+// It returns the URL the feed was actually fetched from.
+func openFeed(ctx context.Context, rawURL string, choose chooseFeed) (*gofeed.Feed, string, error) {
+	candidates, err := candidateURLs(rawURL)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var lastErr error
+
+	for _, candidate := range candidates {
+		feed, links, err := discoverFeed(ctx, candidate)
+		if err != nil {
+			lastErr = err
+
+			continue
+		}
+
+		if feed != nil {
+			return feed, candidate, nil
+		}
+
+		choice := 0
+		if len(links) > 1 {
+			if choice = choose(links); choice == commands.Cancelled {
+				return nil, "", errDiscoveryCancelled
+			}
+		}
+
+		feed, err = RefreshDocument(ctx, links[choice].url, ParseFeed)
+
+		return feed, links[choice].url, err
+	}
+
+	return nil, "", lastErr
+}
+
+// candidateURLs lists the URLs to try, in order. A URL without a scheme is assumed to be https.
+func candidateURLs(rawURL string) ([]string, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(parsed.Host) == 0 {
+		return nil, errInvalidFeedURL
+	}
+
+	switch parsed.Scheme {
+	case "https":
+		return []string{parsed.String()}, nil
+	case "http":
+		upgraded := *parsed
+		upgraded.Scheme = "https"
+
+		return []string{upgraded.String(), parsed.String()}, nil
+	default:
+		return nil, errInvalidFeedURL
+	}
+}
+
+// discoverFeed fetches a URL, which is either a feed or a page linking to feeds.
+func discoverFeed(ctx context.Context, pageURL string) (*gofeed.Feed, []feedLink, error) {
+	response, body, err := requestDocument(ctx, pageURL, "", "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	feed, parseErr := ParseFeed(body)
+	if parseErr == nil {
+		return feed, nil, nil
+	}
+
+	// Links are relative to wherever the page was redirected to
+	links, err := findFeedLinks(body, response.Request.URL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(links) == 0 {
+		return nil, nil, fmt.Errorf("%w (%s)", errNoFeedLinks, parseErr)
+	}
+
+	return nil, links, nil
+}
+
+// findFeedLinks finds the feeds advertised by an HTML page, in the order they appear.
+func findFeedLinks(body []byte, pageURL *url.URL) ([]feedLink, error) {
+	document, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	links := []feedLink{}
+	found := map[string]struct{}{}
+
+	document.Find(`link[rel~="alternate"][href]`).Each(func(_ int, link *goquery.Selection) {
+		linkType := strings.ToLower(strings.TrimSpace(strings.Split(link.AttrOr("type", ""), ";")[0]))
+		if _, isFeed := feedLinkTypes[linkType]; !isFeed {
+			return
+		}
+
+		href, err := pageURL.Parse(strings.TrimSpace(link.AttrOr("href", "")))
+		if err != nil {
+			return
+		}
+
+		if _, duplicate := found[href.String()]; duplicate {
+			return
+		}
+
+		found[href.String()] = struct{}{}
+		links = append(links, feedLink{
+			title: strings.TrimSpace(link.AttrOr("title", linkType)),
+			url:   href.String(),
+		})
+	})
+
+	return links, nil
+}
+
+// feedOpenError explains why a feed couldn't be added.
+func feedOpenError(rawURL string, err error) *commands.CommandError {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, errDiscoveryCancelled):
+		return commands.CreateCommandError("Okay, I didn't add any of them", err)
+	case errors.Is(err, errNoFeedLinks):
+		return commands.CreateCommandError(fmt.Sprintf("%s isn't a feed, and doesn't link to one either", rawURL), err)
+	case errors.Is(err, errInvalidFeedURL):
+		return commands.CreateCommandError(fmt.Sprintf("%s doesn't seem to be a valid URL", rawURL), err)
+	default:
+		return commands.CreateCommandError("Tried to fetch the feed, but some error occurred reading it", err)
+	}
+}
@@ -0,0 +1,169 @@
+package persistent
+
+import (
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/k3a/html2text"
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+	"quozlet.net/birbbot/app/commands"
+)
+
+// Styles a subscription can post items in.
+const (
+	// EmbedStyle posts items as rich embeds, with a summary and thumbnail where the feed has them.
+	EmbedStyle = "embed"
+	// TextStyle posts items as plain text, so Discord previews the link instead.
+	TextStyle = "text"
+)
+
+const (
+	// Discord allows much longer embeds, but a summary should fit in a glance.
+	embedSummaryLength = 300
+	// Discord rejects embed titles longer than this.
+	embedTitleLength = 256
+	// Discord rejects embed descriptions longer than this.
+	embedDescriptionLength = 4096
+	// Discord rejects messages longer than this.
+	maxMessageLength = 2000
+)
+
+// RenderItem builds the message to post an item in the given style.
+func RenderItem(feedTitle string, info RSSInfo, style string) commands.MessageResponse {
+	if style == TextStyle || info.Item == nil {
+		lines := []string{feedTitle, "**" + info.Title + "**", info.Description}
+		if info.Item != nil {
+			if episode := itemEpisode(info.Item); episode != nil {
+				lines = append(lines, episode.Summary())
+			}
+		}
+
+		return commands.MessageResponse{Message: truncate(strings.Join(lines, "\n"), maxMessageLength)}
+	}
+
+	return commands.MessageResponse{Embed: itemEmbed(feedTitle, info)}
+}
+
+func itemEmbed(feedTitle string, info RSSInfo) *discordgo.MessageEmbed {
+	item := info.Item
+	embed := &discordgo.MessageEmbed{
+		Author: &discordgo.MessageEmbedAuthor{Name: truncate(feedTitle, embedTitleLength)},
+		Title:  truncate(info.Title, embedTitleLength),
+		URL:    info.Description,
+	}
+
+	if !strings.HasPrefix(embed.URL, "http") {
+		// The description isn't a link, so it's the best summary there is
+		embed.URL = ""
+		embed.Description = truncate(info.Description, embedSummaryLength)
+	} else {
+		embed.Description = truncate(itemSummary(item), embedSummaryLength)
+	}
+
+	if item.Author != nil && len(item.Author.Name) != 0 {
+		embed.Footer = &discordgo.MessageEmbedFooter{Text: item.Author.Name}
+	}
+
+	if published := item.PublishedParsed; published != nil {
+		embed.Timestamp = published.Format(time.RFC3339)
+	} else if updated := item.UpdatedParsed; updated != nil {
+		embed.Timestamp = updated.Format(time.RFC3339)
+	}
+
+	if thumbnail := itemThumbnail(item); len(thumbnail) != 0 {
+		embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: thumbnail}
+	}
+
+	if episode := itemEpisode(item); episode != nil {
+		embed.Fields = episodeFields(*episode)
+	}
+
+	return embed
+}
+
+func episodeFields(episode Episode) []*discordgo.MessageEmbedField {
+	fields := []*discordgo.MessageEmbedField{}
+
+	for _, field := range []struct{ name, value string }{
+		{"Episode", episode.Label()},
+		{"Duration", episode.FormattedDuration()},
+		{"Format", episode.Media.Type},
+	} {
+		if len(field.value) != 0 {
+			fields = append(fields, &discordgo.MessageEmbedField{
+				Name:   field.name,
+				Value:  truncate(field.value, embedTitleLength),
+				Inline: true,
+			})
+		}
+	}
+
+	return fields
+}
+
+func itemSummary(item *gofeed.Item) string {
+	if len(item.Description) != 0 {
+		return strings.TrimSpace(html2text.HTML2Text(item.Description))
+	}
+
+	return strings.TrimSpace(html2text.HTML2Text(item.Content))
+}
+
+// itemThumbnail finds an image for the item, preferring what the feed says is its image,
+// then podcast artwork, then Media RSS thumbnails and images, then image enclosures.
+func itemThumbnail(item *gofeed.Item) string {
+	if item.Image != nil && len(item.Image.URL) != 0 {
+		return item.Image.URL
+	}
+
+	if item.ITunesExt != nil && len(item.ITunesExt.Image) != 0 {
+		return strings.TrimSpace(item.ITunesExt.Image)
+	}
+
+	if media, found := item.Extensions["media"]; found {
+		if thumbnail := mediaImage(media); len(thumbnail) != 0 {
+			return thumbnail
+		}
+
+		for _, group := range media["group"] {
+			if thumbnail := mediaImage(group.Children); len(thumbnail) != 0 {
+				return thumbnail
+			}
+		}
+	}
+
+	for _, enclosure := range item.Enclosures {
+		if strings.HasPrefix(enclosure.Type, "image/") {
+			return enclosure.URL
+		}
+	}
+
+	return ""
+}
+
+func mediaImage(media map[string][]ext.Extension) string {
+	for _, thumbnail := range media["thumbnail"] {
+		if url := thumbnail.Attrs["url"]; len(url) != 0 {
+			return url
+		}
+	}
+
+	for _, content := range media["content"] {
+		if content.Attrs["medium"] == "image" || strings.HasPrefix(content.Attrs["type"], "image/") {
+			return content.Attrs["url"]
+		}
+	}
+
+	return ""
+}
+
+func truncate(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) > length {
+		return strings.TrimSpace(string(runes[:length-1])) + "…"
+	}
+
+	return text
+}
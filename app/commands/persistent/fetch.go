@@ -0,0 +1,473 @@
+package persistent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/mmcdole/gofeed"
+	"github.com/mmcdole/gofeed/rss"
+)
+
+const (
+	feedFetchState string = "SELECT ID, ETag, LastModified, UpdateInterval, NextFetch, Dead FROM Feeds " +
+		"WHERE ID = ANY($1)"
+	// Until its items have been handled, the next fetch of a feed isn't conditional (nor delayed)
+	feedFetched    string = "UPDATE Feeds SET ETag = '', LastModified = '', Failures = 0 WHERE ID = ANY($1)"
+	feedRemembered string = "UPDATE Feeds SET ETag = $1, LastModified = $2, UpdateInterval = $3, NextFetch = $4 " +
+		"WHERE ID = ANY($5)"
+	feedNotModified string = "UPDATE Feeds SET NextFetch = $1, Failures = 0 WHERE ID = ANY($2)"
+	feedFailed      string = "UPDATE Feeds SET Failures = Failures + 1, Dead = Failures + 1 >= $1 " +
+		"WHERE ID = ANY($2) RETURNING ID, Failures, Dead"
+	feedBackoff string = "UPDATE Feeds SET NextFetch = $1 WHERE ID = ANY($2)"
+	feedRevive  string = "UPDATE Feeds SET NextFetch = NULL, Failures = 0, Dead = FALSE " +
+		"WHERE ID = $1 AND GuildID = $2 AND (Dead OR Failures > 0)"
+)
+
+const (
+	feedFetchTimeout = 60 * time.Second
+	// Feeds (and pages linking to them) larger than this are cut off
+	maxDocumentSize = 10 << 20
+	// A failing feed waits this long before being fetched again, doubling with every failure after that.
+	minFeedBackoff = 30 * time.Minute
+	maxFeedBackoff = 24 * time.Hour
+	userAgent      = "BirbBot (+https://github.com/Quozlet/BirbBot)"
+)
+
+var (
+	// ErrFeedNotModified is returned when the feed hasn't changed since it was last fetched.
+	ErrFeedNotModified = errors.New("feed not modified since it was last fetched")
+	// ErrFeedNotDue is returned when the feed asked not to be fetched yet, or is backing off after failing.
+	ErrFeedNotDue = errors.New("feed isn't due to be fetched yet")
+	// ErrFeedDead is returned when the feed has failed too many times in a row, and is no longer fetched.
+	ErrFeedDead = errors.New("feed failed too many times, and is no longer fetched")
+	// ErrFeedDied is wrapped by FeedDiedError.
+	ErrFeedDied = errors.New("feed failed too many times in a row")
+)
+
+// MaxFeedFailures is how many times in a row a feed can fail before it is no longer fetched, until it is revived.
+const MaxFeedFailures = 10
+
+// Shared by every feed fetch, so connections to the same host are reused.
+var feedClient = &http.Client{Timeout: feedFetchTimeout}
+
+// httpError is a response that wasn't successful, along with how long the server asked to wait before retrying.
+type httpError struct {
+	status     string
+	retryAfter time.Duration
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("fetching the feed failed with %s", e.status)
+}
+
+// DocumentParser reads the items out of a fetched document, e.g. an RSS feed or a watched page.
+type DocumentParser func(body []byte) (*gofeed.Feed, error)
+
+// Fetched is a subscribed feed that was fetched successfully.
+// The fetch isn't remembered until Remember is called, so until then the feed is fetched in full every check.
+type Fetched struct {
+	Feed         *gofeed.Feed
+	ids          []int64
+	etag         string
+	lastModified string
+	interval     *time.Duration
+}
+
+// Remember saves the fetch's conditional headers, and when the feed asked to be fetched next.
+// It should only be called once every subscription has handled the items, otherwise the feed may not be fetched
+// again (e.g. because it wasn't modified) until it has changed, and the items that weren't handled are never found.
+func (f *Fetched) Remember(ctx context.Context, dbPool *pgxpool.Pool) error {
+	_, err := dbPool.Exec(ctx, feedRemembered, f.etag, f.lastModified, f.interval, nextFetchAfter(f.interval), f.ids)
+
+	return err
+}
+
+// FetchFeed fetches a subscribed RSS feed, the same way FetchDocument fetches any other kind of feed.
+func FetchFeed(ctx context.Context, dbPool *pgxpool.Pool, ids []int64, feedURL string) (*Fetched, error) {
+	return FetchDocument(ctx, dbPool, ids, feedURL, ParseFeed)
+}
+
+// FetchDocument fetches a subscribed feed, unless it hasn't changed since it was last fetched, isn't due to be fetched,
+// or is dead (in which case one of the errors above is returned).
+// Every feed with the same URL (e.g. added by different servers) is fetched once, and shares the outcome.
+// Failures (including failing to parse the document) are backed off exponentially (or as long as the server asks),
+// until the feeds are marked as dead.
+func FetchDocument(
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	ids []int64,
+	documentURL string,
+	parse DocumentParser,
+) (*Fetched, error) {
+	state, err := selectFetchState(ctx, dbPool, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(state.alive) == 0:
+		return nil, ErrFeedDead
+	case !state.due:
+		return nil, ErrFeedNotDue
+	}
+
+	feed, headers, err := requestParsed(ctx, documentURL, state.etag, state.lastModified, parse)
+
+	writeCtx, cancel := PostedItemsContext()
+	defer cancel()
+
+	switch {
+	case errors.Is(err, ErrFeedNotModified):
+		_, writeErr := dbPool.Exec(writeCtx, feedNotModified, nextFetchAfter(state.interval), state.alive)
+
+		return nil, firstError(writeErr, err)
+	case err != nil:
+		return nil, recordFailure(writeCtx, dbPool, state.alive, err)
+	}
+
+	if _, err := dbPool.Exec(writeCtx, feedFetched, state.alive); err != nil {
+		return nil, err
+	}
+
+	return &Fetched{
+		Feed:         feed,
+		ids:          state.alive,
+		etag:         headers.Get("ETag"),
+		lastModified: headers.Get("Last-Modified"),
+		interval:     updateInterval(feed),
+	}, nil
+}
+
+// FeedDiedError is returned (wrapping ErrFeedDied) only by the fetch that failed one too many times.
+type FeedDiedError struct {
+	// IDs of the feeds that died, not every feed with the URL will have failed as often
+	IDs []int64
+	err error
+}
+
+func (e *FeedDiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *FeedDiedError) Unwrap() error {
+	return e.err
+}
+
+// fetchState is what the feeds with the same URL remember about fetching it.
+type fetchState struct {
+	alive        []int64
+	due          bool
+	etag         string
+	lastModified string
+	interval     *time.Duration
+}
+
+// selectFetchState combines the state of every feed with the same URL.
+// They are normally fetched together, but the conditional headers are only sent if they all agree on them.
+func selectFetchState(ctx context.Context, dbPool *pgxpool.Pool, ids []int64) (*fetchState, error) {
+	rows, err := dbPool.Query(ctx, feedFetchState, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	state := &fetchState{}
+	conditional := true
+
+	for rows.Next() {
+		var id int64
+
+		var etag, lastModified string
+
+		var interval *time.Duration
+
+		var nextFetch *time.Time
+
+		var dead bool
+		if err := rows.Scan(&id, &etag, &lastModified, &interval, &nextFetch, &dead); err != nil {
+			return nil, err
+		}
+
+		if dead {
+			continue
+		}
+
+		if len(state.alive) == 0 {
+			state.etag, state.lastModified, state.interval = etag, lastModified, interval
+		} else if etag != state.etag || lastModified != state.lastModified {
+			conditional = false
+		}
+
+		state.alive = append(state.alive, id)
+		state.due = state.due || nextFetch == nil || !time.Now().Before(*nextFetch)
+	}
+
+	if !conditional {
+		state.etag, state.lastModified = "", ""
+	}
+
+	return state, rows.Err()
+}
+
+// ReviveFeed lets a dead (or backing off) feed be fetched right away, reporting whether the feed was failing.
+func ReviveFeed(ctx context.Context, dbPool *pgxpool.Pool, id int64, guildID string) (bool, error) {
+	tag, err := dbPool.Exec(ctx, feedRevive, id, guildID)
+
+	return tag.RowsAffected() != 0, err
+}
+
+// RefreshFeed fetches a given RSS feed.
+// Unlike FetchFeed, it is always fetched, and failures aren't recorded.
+func RefreshFeed(ctx context.Context, url *url.URL) (*gofeed.Feed, error) {
+	return RefreshDocument(ctx, url.String(), ParseFeed)
+}
+
+// RefreshDocument fetches any kind of feed, the way RefreshFeed fetches an RSS feed.
+func RefreshDocument(ctx context.Context, documentURL string, parse DocumentParser) (*gofeed.Feed, error) {
+	feed, _, err := requestParsed(ctx, documentURL, "", "", parse)
+
+	return feed, err
+}
+
+func requestParsed(
+	ctx context.Context,
+	documentURL, etag, lastModified string,
+	parse DocumentParser,
+) (*gofeed.Feed, http.Header, error) {
+	response, body, err := requestDocument(ctx, documentURL, etag, lastModified)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	feed, err := parse(body)
+
+	return feed, response.Header, err
+}
+
+// requestDocument reads a feed (or a page that might link to one), conditionally if the headers from a previous
+// response are provided.
+func requestDocument(ctx context.Context, documentURL, etag, lastModified string) (*http.Response, []byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, feedFetchTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, documentURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	request.Header.Set("User-Agent", userAgent)
+
+	if len(etag) != 0 {
+		request.Header.Set("If-None-Match", etag)
+	}
+
+	if len(lastModified) != 0 {
+		request.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	response, err := feedClient.Do(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusNotModified:
+		return response, nil, ErrFeedNotModified
+	case response.StatusCode < 200 || response.StatusCode >= 300:
+		return response, nil, &httpError{
+			status:     response.Status,
+			retryAfter: parseRetryAfter(response.Header.Get("Retry-After")),
+		}
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxDocumentSize))
+
+	return response, body, err
+}
+
+// ParseFeed parses an RSS, Atom or JSON feed.
+func ParseFeed(body []byte) (*gofeed.Feed, error) {
+	parser := gofeed.NewParser()
+	parser.RSSTranslator = &ttlTranslator{}
+	parser.JSONTranslator = &jsonTranslator{}
+
+	return parser.Parse(bytes.NewReader(body))
+}
+
+// recordFailure backs off the feeds, and marks them as dead once they have failed too many times.
+func recordFailure(ctx context.Context, dbPool *pgxpool.Pool, ids []int64, fetchErr error) error {
+	rows, err := dbPool.Query(ctx, feedFailed, MaxFeedFailures, ids)
+	if err != nil {
+		return firstError(err, fetchErr)
+	}
+	defer rows.Close()
+
+	failures := 0
+	died := []int64{}
+
+	for rows.Next() {
+		var id int64
+
+		var failed int
+
+		var dead bool
+		if err := rows.Scan(&id, &failed, &dead); err != nil {
+			return firstError(err, fetchErr)
+		}
+
+		if failed > failures {
+			failures = failed
+		}
+
+		if dead {
+			died = append(died, id)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return firstError(err, fetchErr)
+	}
+
+	// The feeds were removed while they were being fetched, so there's nothing to back off
+	if failures == 0 {
+		return fetchErr
+	}
+
+	if _, err := dbPool.Exec(ctx, feedBackoff, time.Now().Add(failureBackoff(failures, fetchErr)), ids); err != nil {
+		return firstError(err, fetchErr)
+	}
+
+	if len(died) != 0 {
+		return &FeedDiedError{
+			IDs: died,
+			err: fmt.Errorf("%w (%d times), most recently: %s", ErrFeedDied, failures, fetchErr),
+		}
+	}
+
+	return fetchErr
+}
+
+// failureBackoff is how long to wait before fetching a feed that has failed this many times in a row.
+// It doubles with every failure, up to a maximum, unless the server asked to wait even longer.
+func failureBackoff(failures int, fetchErr error) time.Duration {
+	if failures < 1 {
+		failures = 1
+	}
+
+	backoff := minFeedBackoff << (failures - 1)
+	if backoff > maxFeedBackoff || backoff <= 0 {
+		backoff = maxFeedBackoff
+	}
+
+	var statusErr *httpError
+	if errors.As(fetchErr, &statusErr) && statusErr.retryAfter > backoff {
+		backoff = statusErr.retryAfter
+	}
+
+	return backoff
+}
+
+func nextFetchAfter(interval *time.Duration) *time.Time {
+	if interval == nil {
+		return nil
+	}
+
+	next := time.Now().Add(*interval)
+
+	return &next
+}
+
+// parseRetryAfter parses either form of the Retry-After header (seconds, or a date).
+func parseRetryAfter(header string) time.Duration {
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(header); err == nil {
+		return time.Until(date)
+	}
+
+	return 0
+}
+
+// updateInterval is how often the feed says it should be fetched (if at all), from RSS <ttl> or sy:updatePeriod.
+func updateInterval(feed *gofeed.Feed) *time.Duration {
+	if minutes, err := strconv.Atoi(feed.Custom["ttl"]); err == nil && minutes > 0 {
+		interval := time.Duration(minutes) * time.Minute
+
+		return &interval
+	}
+
+	syndication, found := feed.Extensions["sy"]
+	if !found || len(syndication["updatePeriod"]) == 0 {
+		return nil
+	}
+
+	periods := map[string]time.Duration{
+		"hourly":  time.Hour,
+		"daily":   24 * time.Hour,
+		"weekly":  7 * 24 * time.Hour,
+		"monthly": 30 * 24 * time.Hour,
+		"yearly":  365 * 24 * time.Hour,
+	}
+
+	period, found := periods[strings.TrimSpace(syndication["updatePeriod"][0].Value)]
+	if !found {
+		return nil
+	}
+
+	frequency := 1
+	if len(syndication["updateFrequency"]) != 0 {
+		parsed, err := strconv.Atoi(strings.TrimSpace(syndication["updateFrequency"][0].Value))
+		if err == nil && parsed > 0 {
+			frequency = parsed
+		}
+	}
+
+	interval := period / time.Duration(frequency)
+
+	return &interval
+}
+
+// ttlTranslator keeps the RSS <ttl>, which the default translator drops.
+type ttlTranslator struct {
+	gofeed.DefaultRSSTranslator
+}
+
+func (t *ttlTranslator) Translate(feed interface{}) (*gofeed.Feed, error) {
+	translated, err := t.DefaultRSSTranslator.Translate(feed)
+	if err != nil {
+		return nil, err
+	}
+
+	if rssFeed, isRSS := feed.(*rss.Feed); isRSS && len(rssFeed.TTL) != 0 {
+		if translated.Custom == nil {
+			translated.Custom = map[string]string{}
+		}
+
+		translated.Custom["ttl"] = strings.TrimSpace(rssFeed.TTL)
+	}
+
+	return translated, nil
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
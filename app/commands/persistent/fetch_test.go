@@ -0,0 +1,63 @@
+package persistent
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestFailureBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		err      error
+		want     time.Duration
+	}{
+		{name: "no failures recorded", failures: 0, want: minFeedBackoff},
+		{name: "negative failures", failures: -3, want: minFeedBackoff},
+		{name: "first failure", failures: 1, want: minFeedBackoff},
+		{name: "doubles", failures: 2, want: 2 * minFeedBackoff},
+		{name: "doubles again", failures: 3, want: 4 * minFeedBackoff},
+		{name: "capped", failures: 7, want: maxFeedBackoff},
+		{name: "capped when the shift overflows", failures: 100, want: maxFeedBackoff},
+		{
+			name:     "server asks for longer",
+			failures: 1,
+			err:      &httpError{status: "429 Too Many Requests", retryAfter: 2 * time.Hour},
+			want:     2 * time.Hour,
+		},
+		{
+			name:     "server asks for less",
+			failures: 3,
+			err:      fmt.Errorf("wrapped: %w", &httpError{status: "503", retryAfter: time.Minute}),
+			want:     4 * minFeedBackoff,
+		},
+		{name: "other errors", failures: 2, err: errors.New("timeout"), want: 2 * minFeedBackoff},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			if got := failureBackoff(test.failures, test.err); got != test.want {
+				t.Errorf("failureBackoff(%d, %v) = %s, want %s", test.failures, test.err, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("120"); got != 2*time.Minute {
+		t.Errorf("parseRetryAfter(120) = %s, want 2m", got)
+	}
+
+	if got := parseRetryAfter(""); got != 0 {
+		t.Errorf("parseRetryAfter of nothing = %s, want 0", got)
+	}
+
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(date); got < 58*time.Minute || got > time.Hour {
+		t.Errorf("parseRetryAfter(%s) = %s, want about an hour", date, got)
+	}
+}
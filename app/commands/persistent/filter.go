@@ -11,18 +11,48 @@ import (
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/mmcdole/gofeed"
 	"quozlet.net/birbbot/app/commands"
 )
 
 const (
-	filterTableDefinition = "CREATE TABLE IF NOT EXISTS Filters " +
-		"(ID SERIAL PRIMARY KEY, Regex TEXT UNIQUE NOT NULL, FeedID INTEGER REFERENCES Feeds(ID))"
-	filterInsert = "INSERT INTO Filters(Regex) VALUES ($1) ON CONFLICT (Regex) DO NOTHING"
-	filterApply  = "UPDATE Filters SET FeedID = $1 WHERE ID = $2"
-	filterList   = "SELECT ID, Regex FROM Filters"
-	filterSelect = "SELECT Regex FROM Filters WHERE FeedID = $1"
+	filterInsert = "INSERT INTO Filters(GuildID, Regex, Kind, Fields) VALUES ($1, $2, $3, $4) " +
+		"ON CONFLICT (GuildID, Kind, Fields, Regex) DO NOTHING"
+	// Both the filter and the feed must belong to the server
+	filterApply = "INSERT INTO FeedFilters(FeedID, FilterID) SELECT Feeds.ID, Filters.ID FROM Feeds, Filters " +
+		"WHERE Feeds.ID = $1 AND Filters.ID = $2 AND Feeds.GuildID = $3 AND Filters.GuildID = $3 " +
+		"ON CONFLICT DO NOTHING"
+	filterUnapply = "DELETE FROM FeedFilters USING Filters WHERE FeedFilters.FilterID = Filters.ID " +
+		"AND FeedFilters.FeedID = $1 AND FeedFilters.FilterID = $2 AND Filters.GuildID = $3"
+	filterList = "SELECT Filters.ID, Filters.Regex, Filters.Kind, Filters.Fields, " +
+		"ARRAY_REMOVE(ARRAY_AGG(FeedFilters.FeedID ORDER BY FeedFilters.FeedID), NULL) " +
+		"FROM Filters LEFT JOIN FeedFilters ON FeedFilters.FilterID = Filters.ID WHERE Filters.GuildID = $1 " +
+		"GROUP BY Filters.ID ORDER BY Filters.ID"
+	filterSelect = "SELECT Filters.Regex, Filters.Kind, Filters.Fields FROM Filters " +
+		"JOIN FeedFilters ON FeedFilters.FilterID = Filters.ID WHERE FeedFilters.FeedID = $1"
+	filterFind = "SELECT Regex, (SELECT COUNT(*) FROM FeedFilters WHERE FilterID = $1) " +
+		"FROM Filters WHERE ID = $1 AND GuildID = $2"
+	filterDelete = "DELETE FROM Filters WHERE ID = $1 AND GuildID = $2"
 )
 
+// Filters either include (only items matching at least one are posted) or exclude (no items matching are posted).
+const (
+	includeFilter = "include"
+	excludeFilter = "exclude"
+)
+
+// Fields of an item that a filter can match against, where link includes any enclosure URLs.
+const (
+	titleField       = "title"
+	linkField        = "link"
+	descriptionField = "description"
+	contentField     = "content"
+)
+
+const filterFieldsPrefix = "in:"
+
+var allFilterFields = []string{titleField, linkField, descriptionField, contentField}
+
 const (
 	feedReadErrorMsg      = "Error occurred reading the feeds, aborting"
 	invalidFeedIDErrorMsg = "%s is not a valid number to use as an ID"
@@ -31,20 +61,14 @@ const (
 // Filter is a command to store a regular expression (regex) for filtering RSS feeds.
 type Filter struct{}
 
-// Check creates the table if it does not already exists, with an error if unable to do so.
+// Check returns nil, the Filters table is created by migrations.
 func (f Filter) Check(dbPool *pgxpool.Pool) error {
-	tag, err := dbPool.Exec(context.Background(), filterTableDefinition)
-	if err != nil {
-		return err
-	}
-
-	log.Printf("Filter: %s", tag)
-
 	return nil
 }
 
 // ProcessMessage for a Filter command will either apply or create a filter.
 func (f Filter) ProcessMessage(
+	ctx context.Context,
 	response chan<- commands.MessageResponse,
 	m *discordgo.MessageCreate,
 	dbPool *pgxpool.Pool,
@@ -54,42 +78,67 @@ func (f Filter) ProcessMessage(
 		return commands.NewError("There needs to be at least something to use as a subcommand or regex")
 	}
 
+	if len(m.GuildID) == 0 {
+		return commands.NewError(guildOnlyErrorMsg)
+	}
+
 	message := splitContent[1:]
 
 	switch message[0] {
 	case "list":
-		return listRegex(response, m.ChannelID, dbPool)
+		return listRegex(ctx, response, m.ChannelID, m.GuildID, dbPool)
 	case "apply":
-		return applyRegex(response, m.ChannelID, message[1:], dbPool)
+		return applyRegex(ctx, response, m.ChannelID, m.GuildID, message[1:], true, dbPool)
+	case "unapply":
+		return applyRegex(ctx, response, m.ChannelID, m.GuildID, message[1:], false, dbPool)
+	case "remove":
+		return removeRegex(ctx, response, m, message[1:], dbPool)
 	default:
-		return handlePossibleRegex(response, m.ChannelID, m.Content, dbPool)
+		return handlePossibleRegex(ctx, response, m.ChannelID, m.GuildID, m.Content, dbPool)
 	}
 }
 
 func handlePossibleRegex(
+	ctx context.Context,
 	response chan<- commands.MessageResponse,
 	channelID string,
+	guildID string,
 	exp string,
 	dbPool *pgxpool.Pool,
 ) *commands.CommandError {
 	var commandError *commands.CommandError
 
-	possibleRegexIndex := strings.IndexRune(exp, ' ')
+	// Everything after the command (and the optional type and fields) is the regex, spaces included
+	_, exp = splitArgument(exp)
+	kind := includeFilter
 
-	if possibleRegexIndex < -1 {
-		return commands.NewError(fmt.Sprintf("Failed to parse '%s'", exp))
+	if next, rest := splitArgument(exp); next == includeFilter || next == excludeFilter {
+		kind, exp = next, rest
 	}
 
-	regex, err := regexp.Compile(strings.TrimLeftFunc(string([]rune(exp)[possibleRegexIndex:]), unicode.IsSpace))
+	fields := allFilterFields
+	if next, rest := splitArgument(exp); strings.HasPrefix(next, filterFieldsPrefix) {
+		if fields, commandError = parseFilterFields(strings.TrimPrefix(next, filterFieldsPrefix)); commandError != nil {
+			return commandError
+		}
+
+		exp = rest
+	}
+
+	if len(exp) == 0 {
+		return commands.NewError("There needs to be a regex to filter with, see `help filter`")
+	}
+
+	regex, err := regexp.Compile(exp)
 
 	if commandError = commands.CreateCommandError(
-		fmt.Sprintf("Failed to parse '%s'", string([]rune(exp)[possibleRegexIndex:])),
+		fmt.Sprintf("Failed to parse '%s'", exp),
 		err,
 	); commandError != nil {
 		return commandError
 	}
 
-	tag, err := dbPool.Exec(context.Background(), filterInsert, regex.String())
+	tag, err := dbPool.Exec(ctx, filterInsert, guildID, regex.String(), kind, fields)
 
 	if commandError = commands.CreateCommandError(
 		"Parsed as a valid regex, but failed to save. Try again!",
@@ -98,18 +147,26 @@ func handlePossibleRegex(
 		return commandError
 	}
 
-	log.Printf("Filter: %s (actually inserted %s)", tag, regex)
+	if tag.RowsAffected() == 0 {
+		return commands.NewError("That filter has already been saved, see `filter list`")
+	}
+
+	log.Printf("Filter: %s (actually inserted %s %s on %v for %s)", tag, kind, regex, fields, guildID)
 	response <- commands.MessageResponse{
 		ChannelID: channelID,
-		Message:   "Saved successfully. Use `!filter apply` to apply for a feed",
+		Message:   "Saved successfully. Use `filter apply` to apply for a feed",
 	}
 
 	return nil
 }
 
-func applyRegex(response chan<- commands.MessageResponse,
+func applyRegex(
+	ctx context.Context,
+	response chan<- commands.MessageResponse,
 	channelID string,
+	guildID string,
 	ids []string,
+	apply bool,
 	dbPool *pgxpool.Pool,
 ) *commands.CommandError {
 	var commandError *commands.CommandError
@@ -119,7 +176,7 @@ func applyRegex(response chan<- commands.MessageResponse,
 			"See `!help filter` for more info)")
 	}
 
-	regexID, err := strconv.ParseInt(ids[0], 0, 64)
+	feedID, err := strconv.ParseInt(ids[0], 0, 64)
 
 	if commandError = commands.CreateCommandError(
 		fmt.Sprintf(invalidFeedIDErrorMsg, ids[0]),
@@ -128,7 +185,7 @@ func applyRegex(response chan<- commands.MessageResponse,
 		return commandError
 	}
 
-	feedID, err := strconv.ParseInt(ids[1], 0, 64)
+	regexID, err := strconv.ParseInt(ids[1], 0, 64)
 
 	if commandError = commands.CreateCommandError(
 		fmt.Sprintf(invalidFeedIDErrorMsg, ids[1]),
@@ -137,33 +194,107 @@ func applyRegex(response chan<- commands.MessageResponse,
 		return commandError
 	}
 
-	tag, err := dbPool.Exec(context.Background(), filterApply, regexID, feedID)
+	query, action := filterApply, "applied"
+	if !apply {
+		query, action = filterUnapply, "unapplied"
+	}
+
+	tag, err := dbPool.Exec(ctx, query, feedID, regexID, guildID)
 
 	if commandError = commands.CreateCommandError(
-		fmt.Sprintf("Failed to apply that filter. "+
+		fmt.Sprintf("Failed to change that filter. "+
 			"Check that %d is a valid filter ID, and %d a valid RSS ID", regexID, feedID),
 		err,
 	); commandError != nil {
 		return commandError
 	}
 
-	log.Printf("Filter: %s (actually inserted RegexID %d, FeedID %d)", tag, regexID, feedID)
+	if tag.RowsAffected() == 0 {
+		return commands.NewError(fmt.Sprintf("Either %d was already %s to %d, or one of them isn't in this server. "+
+			"Check `filter list` and `rss list`", regexID, action, feedID))
+	}
+
+	log.Printf("Filter: %s (actually %s RegexID %d, FeedID %d)", tag, action, regexID, feedID)
 	response <- commands.MessageResponse{
 		ChannelID: channelID,
-		Message:   fmt.Sprintf("Successfully associated %d to feed %d", regexID, feedID),
+		Message:   fmt.Sprintf("Successfully %s %d to feed %d", action, regexID, feedID),
+	}
+
+	return nil
+}
+
+func removeRegex(
+	ctx context.Context,
+	response chan<- commands.MessageResponse,
+	m *discordgo.MessageCreate,
+	ids []string,
+	dbPool *pgxpool.Pool,
+) *commands.CommandError {
+	var commandError *commands.CommandError
+
+	if len(ids) == 0 {
+		return commands.NewError("Which filter? Provide an ID from `filter list`")
+	}
+
+	regexID, err := strconv.ParseInt(ids[0], 0, 64)
+	if commandError = commands.CreateCommandError(
+		fmt.Sprintf(invalidFeedIDErrorMsg, ids[0]),
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	var regex string
+
+	var feeds int64
+	if commandError = commands.CreateCommandError(
+		fmt.Sprintf("%d isn't a filter in this server, check `filter list`", regexID),
+		dbPool.QueryRow(ctx, filterFind, regexID, m.GuildID).Scan(&regex, &feeds),
+	); commandError != nil {
+		return commandError
+	}
+
+	prompt := fmt.Sprintf("Remove the filter `%s`?", regex)
+	if feeds != 0 {
+		prompt = fmt.Sprintf("Remove the filter `%s`? It is applied to %d feeds, which will stop using it", regex, feeds)
+	}
+
+	if !commands.Confirm(ctx, response, m.ChannelID, m.Author.ID, prompt) {
+		response <- commands.MessageResponse{
+			ChannelID: m.ChannelID,
+			Message:   removeCancelMsg,
+		}
+
+		return nil
+	}
+
+	tag, err := dbPool.Exec(ctx, filterDelete, regexID, m.GuildID)
+	if commandError = commands.CreateCommandError(
+		"Failed to remove that filter, it's still there",
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	log.Printf("Filter: %s (actually removed %d from %s)", tag, regexID, m.GuildID)
+	response <- commands.MessageResponse{
+		ChannelID: m.ChannelID,
+		Message:   fmt.Sprintf("Removed the filter `%s`", regex),
 	}
 
 	return nil
 }
 
 func listRegex(
+	ctx context.Context,
 	response chan<- commands.MessageResponse,
 	channelID string,
+	guildID string,
 	dbPool *pgxpool.Pool,
 ) *commands.CommandError {
 	var commandError *commands.CommandError
 
-	rows, err := dbPool.Query(context.Background(), filterList)
+	rows, err := dbPool.Query(ctx, filterList, guildID)
 
 	if commandError = commands.CreateCommandError(
 		"Sorry, failed to lookup filters. Doesn't mean there aren't any though, so try again",
@@ -171,6 +302,7 @@ func listRegex(
 	); commandError != nil {
 		return commandError
 	}
+	defer rows.Close()
 
 	sentFilters := false
 
@@ -178,17 +310,28 @@ func listRegex(
 		var id int64
 
 		var regex string
+
+		var kind string
+
+		var fields []string
+
+		var feeds []int64
 		if commandError = commands.CreateCommandError(
 			feedReadErrorMsg,
-			rows.Scan(&id, &regex),
+			rows.Scan(&id, &regex, &kind, &fields, &feeds),
 		); commandError != nil {
 			return commandError
 		}
 
+		applied := "not applied to any feeds"
+		if len(feeds) != 0 {
+			applied = fmt.Sprintf("applied to %s", strings.Trim(fmt.Sprint(feeds), "[]"))
+		}
+
 		sentFilters = true
 		response <- commands.MessageResponse{
 			ChannelID: channelID,
-			Message:   fmt.Sprintf("%d: %s", id, regex),
+			Message:   fmt.Sprintf("%d: %s `%s` in %s (%s)", id, kind, regex, strings.Join(fields, ", "), applied),
 		}
 	}
 
@@ -211,6 +354,17 @@ func listRegex(
 	return nil
 }
 
+// Permission reports the permission the Filter Command requires by default.
+// Filters change what is posted into channels, so they require being able to manage them.
+func (f Filter) Permission() int64 {
+	return discordgo.PermissionManageChannels
+}
+
+// Permissioned reports whether the arguments change the filters, which everything but listing them does.
+func (f Filter) Permissioned(args []string) bool {
+	return len(args) == 0 || args[0] != "list"
+}
+
 // CommandList returns a list of aliases for the Filter Command.
 func (f Filter) CommandList() []string {
 	return []string{"filter"}
@@ -218,21 +372,137 @@ func (f Filter) CommandList() []string {
 
 // Help returns the helper message for the Filter Command.
 func (f Filter) Help() string {
-	return "`filter <regular expression>` saves a regular expression filter to apply to a certain RSS feed.\n" +
+	return "`filter [include|exclude] [in:<fields>] <regular expression>` saves a regular expression filter " +
+		"to apply to RSS feeds.\n" +
 		"_Check https://regex101.com/ to create and test regex._\n\n" +
+		"- Only items matching at least one `include` filter (the default) are posted, " +
+		"and items matching any `exclude` filter never are\n" +
+		"- Fields are any of `title`, `link`, `description` and `content`, separated by commas " +
+		"(e.g. `in:title,link`). By default, all of them are matched\n\n" +
 		"- `filter apply <feed id> <regex id>` to apply the filter for an existing subscription.\n" +
-		"_Each possible description will be filtered, and only the (first) matching for each item will be posted._\n\n" +
-		"- `filter list` lists all set filters and their content"
+		"_A feed can have many filters, and a filter can be applied to many feeds._\n" +
+		"- `filter unapply <feed id> <regex id>` stops applying the filter to the feed\n" +
+		"- `filter list` lists all set filters and their content\n" +
+		"- `filter remove <regex id>` removes the filter (after you confirm)"
+}
+
+// ItemFilter is a saved filter, deciding whether RSS items are posted.
+type ItemFilter struct {
+	Regex   *regexp.Regexp
+	Exclude bool
+	Fields  []string
 }
 
-// FetchRegex fetches the regex for a given RSS Feed's ID.
-func FetchRegex(id int64, dbPool *pgxpool.Pool) *regexp.Regexp {
-	var regexString string
-	if err := dbPool.QueryRow(context.Background(), filterSelect, id).Scan(&regexString); err != nil {
-		log.Println(err)
+// FilterSet is every filter applied to a feed.
+type FilterSet []ItemFilter
+
+// Allows reports whether an item should be posted.
+// Any matching exclude filter rejects it, otherwise if there are include filters, at least one must match.
+func (f FilterSet) Allows(item *gofeed.Item) bool {
+	hasInclude, included := false, false
+
+	for _, filter := range f {
+		matches := filter.matches(item)
+		if filter.Exclude && matches {
+			return false
+		}
 
+		if !filter.Exclude {
+			hasInclude = true
+			included = included || matches
+		}
+	}
+
+	return !hasInclude || included
+}
+
+func (f ItemFilter) matches(item *gofeed.Item) bool {
+	for _, field := range f.Fields {
+		for _, value := range fieldValues(item, field) {
+			if f.Regex.MatchString(value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func fieldValues(item *gofeed.Item, field string) []string {
+	switch field {
+	case titleField:
+		return []string{item.Title}
+	case linkField:
+		links := []string{item.Link}
+		for _, enclosure := range item.Enclosures {
+			links = append(links, enclosure.URL)
+		}
+
+		return links
+	case descriptionField:
+		return []string{item.Description}
+	case contentField:
+		return []string{item.Content}
+	default:
 		return nil
 	}
-	// To be stored in the database it must've compiled.
-	return regexp.MustCompile(regexString)
+}
+
+// FetchFilters fetches every filter applied to a given RSS Feed's ID.
+// If they can't be fetched, nothing would be filtered, so an error is returned instead.
+func FetchFilters(ctx context.Context, id int64, dbPool *pgxpool.Pool) (FilterSet, error) {
+	rows, err := dbPool.Query(ctx, filterSelect, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	filters := FilterSet{}
+
+	for rows.Next() {
+		var regex string
+
+		var kind string
+
+		var fields []string
+		if err := rows.Scan(&regex, &kind, &fields); err != nil {
+			return nil, err
+		}
+		// To be stored in the database it must've compiled.
+		filters = append(filters, ItemFilter{
+			Regex:   regexp.MustCompile(regex),
+			Exclude: kind == excludeFilter,
+			Fields:  fields,
+		})
+	}
+
+	return filters, rows.Err()
+}
+
+func parseFilterFields(list string) ([]string, *commands.CommandError) {
+	fields := []string{}
+
+	for _, field := range strings.Split(list, ",") {
+		switch field {
+		case titleField, linkField, descriptionField, contentField:
+			fields = append(fields, field)
+		default:
+			return nil, commands.NewError(fmt.Sprintf("`%s` isn't a field that can be filtered, "+
+				"use any of `%s`", field, strings.Join(allFilterFields, "`, `")))
+		}
+	}
+
+	return fields, nil
+}
+
+// splitArgument splits the first whitespace-separated argument from the rest of the text.
+func splitArgument(text string) (string, string) {
+	text = strings.TrimLeftFunc(text, unicode.IsSpace)
+
+	end := strings.IndexFunc(text, unicode.IsSpace)
+	if end == -1 {
+		return text, ""
+	}
+
+	return text[:end], strings.TrimLeftFunc(text[end:], unicode.IsSpace)
 }
@@ -0,0 +1,73 @@
+package persistent
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func TestFilterSetAllows(t *testing.T) {
+	include := func(regex string, fields ...string) ItemFilter {
+		if len(fields) == 0 {
+			fields = allFilterFields
+		}
+
+		return ItemFilter{Regex: regexp.MustCompile(regex), Fields: fields}
+	}
+	exclude := func(regex string, fields ...string) ItemFilter {
+		filter := include(regex, fields...)
+		filter.Exclude = true
+
+		return filter
+	}
+
+	item := &gofeed.Item{
+		Title:       "Birbs of the week",
+		Link:        "https://example.com/posts/birbs",
+		Description: "A roundup of birds",
+		Content:     "<p>Sponsored</p>",
+		Enclosures:  []*gofeed.Enclosure{{URL: "https://cdn.example.com/birbs.mp3"}},
+	}
+
+	tests := []struct {
+		name    string
+		filters FilterSet
+		want    bool
+	}{
+		{name: "no filters", filters: FilterSet{}, want: true},
+		{name: "matching include", filters: FilterSet{include("(?i)birbs")}, want: true},
+		{name: "no matching include", filters: FilterSet{include("cats")}, want: false},
+		{name: "any include can match", filters: FilterSet{include("cats"), include("roundup")}, want: true},
+		{name: "matching exclude", filters: FilterSet{exclude("Sponsored")}, want: false},
+		{name: "no matching exclude", filters: FilterSet{exclude("cats")}, want: true},
+		{
+			name:    "exclude beats include",
+			filters: FilterSet{include("Birbs"), exclude("Sponsored")},
+			want:    false,
+		},
+		{
+			name:    "exclude beats include in any order",
+			filters: FilterSet{exclude("Sponsored"), include("Birbs")},
+			want:    false,
+		},
+		{
+			name:    "include with a non-matching exclude",
+			filters: FilterSet{include("Birbs"), exclude("cats")},
+			want:    true,
+		},
+		{name: "only the filter's fields", filters: FilterSet{include("roundup", titleField)}, want: false},
+		{name: "links include enclosures", filters: FilterSet{include(`\.mp3$`, linkField)}, want: true},
+		{name: "content field", filters: FilterSet{exclude("Sponsored", contentField)}, want: false},
+		{name: "exclude in other fields", filters: FilterSet{exclude("Sponsored", titleField)}, want: true},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.filters.Allows(item); got != test.want {
+				t.Errorf("Allows = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package persistent
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"quozlet.net/birbbot/app/commands"
+)
+
+const opmlExportList string = "SELECT Feeds.Title, Feeds.URL, " +
+	"COALESCE(array_agg(Subscriptions.Channel ORDER BY Subscriptions.Channel) " +
+	"FILTER (WHERE Subscriptions.Channel IS NOT NULL), '{}') " +
+	"FROM Feeds LEFT JOIN Subscriptions ON Subscriptions.FeedID = Feeds.ID " +
+	"WHERE Feeds.GuildID = $1 AND Feeds.Kind = 'rss' GROUP BY Feeds.ID ORDER BY Feeds.ID"
+
+const (
+	// Discord attachments can be much larger, but no list of feeds should be
+	maxOPMLSize = 1 << 20
+	// How many feeds from an import are fetched at the same time
+	importWorkers = 4
+)
+
+// opml is the subset of an OPML document that describes feeds.
+type opml struct {
+	XMLName xml.Name  `xml:"opml"`
+	Version string    `xml:"version,attr"`
+	Title   string    `xml:"head>title"`
+	Created string    `xml:"head>dateCreated,omitempty"`
+	Body    []outline `xml:"body>outline"`
+}
+
+// outline is a feed, or (when it has no URL) a folder of them.
+type outline struct {
+	Type     string    `xml:"type,attr,omitempty"`
+	Text     string    `xml:"text,attr"`
+	Title    string    `xml:"title,attr,omitempty"`
+	XMLURL   string    `xml:"xmlUrl,attr,omitempty"`
+	Channels string    `xml:"channels,attr,omitempty"`
+	Outlines []outline `xml:"outline"`
+}
+
+// feedURLs lists the URL of every feed in the outline and its children, in the order they appear.
+func (o outline) feedURLs() []string {
+	urls := []string{}
+	if len(o.XMLURL) != 0 {
+		urls = append(urls, o.XMLURL)
+	}
+
+	for _, child := range o.Outlines {
+		urls = append(urls, child.feedURLs()...)
+	}
+
+	return urls
+}
+
+// importResult is what happened to one of the feeds being imported.
+type importResult struct {
+	url   string
+	added bool
+	err   *commands.CommandError
+}
+
+func importFeeds(
+	ctx context.Context,
+	response chan<- commands.MessageResponse,
+	m *discordgo.MessageCreate,
+	dbPool *pgxpool.Pool,
+) *commands.CommandError {
+	if len(m.Attachments) == 0 {
+		return commands.NewError("Attach an OPML file to the message, and I'll add every feed in it")
+	}
+
+	document, err := downloadOPML(ctx, m.Attachments[0])
+	if commandError := commands.CreateCommandError(
+		fmt.Sprintf("Couldn't read %s as an OPML file", m.Attachments[0].Filename),
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	urls := []string{}
+	for _, entry := range document.Body {
+		urls = append(urls, entry.feedURLs()...)
+	}
+
+	if len(urls) == 0 {
+		return commands.NewError(fmt.Sprintf("There aren't any feeds in %s", m.Attachments[0].Filename))
+	}
+
+	results := make([]importResult, len(urls))
+	next := make(chan int)
+	wait := sync.WaitGroup{}
+
+	for worker := 0; worker < importWorkers; worker++ {
+		wait.Add(1)
+
+		go func() {
+			defer wait.Done()
+
+			for i := range next {
+				results[i] = importFeed(ctx, dbPool, m.GuildID, urls[i])
+			}
+		}()
+	}
+
+	for i := range urls {
+		next <- i
+	}
+
+	close(next)
+	wait.Wait()
+
+	response <- commands.MessageResponse{
+		ChannelID: m.ChannelID,
+		Message:   truncate(importSummary(results), maxMessageLength),
+	}
+
+	return nil
+}
+
+// importFeed adds one of the imported feeds, recovering from a panic so that a broken feed is only a failed import.
+func importFeed(ctx context.Context, dbPool *pgxpool.Pool, guildID, feedURL string) (result importResult) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			result = importResult{url: feedURL, err: commands.RecoverError(recovered)}
+		}
+	}()
+
+	_, added, commandError := addFeed(ctx, dbPool, guildID, feedURL, firstFeed)
+
+	return importResult{url: feedURL, added: added, err: commandError}
+}
+
+// importSummary counts the feeds that were added, and lists why the others couldn't be.
+func importSummary(results []importResult) string {
+	added, existing := 0, 0
+	failures := []string{}
+
+	for _, result := range results {
+		switch {
+		case result.err != nil:
+			failures = append(failures, fmt.Sprintf("- <%s>: %s", result.url, result.err.Error()))
+		case result.added:
+			added++
+		default:
+			existing++
+		}
+	}
+
+	summary := fmt.Sprintf("Added %d feeds (%d were already added)", added, existing)
+	if len(failures) != 0 {
+		summary += fmt.Sprintf(", but couldn't add %d:\n%s", len(failures), strings.Join(failures, "\n"))
+	}
+
+	return summary
+}
+
+func downloadOPML(ctx context.Context, attachment *discordgo.MessageAttachment) (*opml, error) {
+	if attachment.Size > maxOPMLSize {
+		return nil, fmt.Errorf("%s is %d bytes, too large to be imported", attachment.Filename, attachment.Size)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, attachment.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	downloaded, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer downloaded.Body.Close()
+
+	if downloaded.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading %s failed with %s", attachment.Filename, downloaded.Status)
+	}
+
+	document := &opml{}
+	if err := xml.NewDecoder(io.LimitReader(downloaded.Body, maxOPMLSize)).Decode(document); err != nil {
+		return nil, err
+	}
+
+	return document, nil
+}
+
+func exportFeeds(
+	ctx context.Context,
+	response chan<- commands.MessageResponse,
+	channelID string,
+	guildID string,
+	dbPool *pgxpool.Pool,
+) *commands.CommandError {
+	var commandError *commands.CommandError
+
+	document, err := selectOPML(ctx, dbPool, guildID)
+	if commandError = commands.CreateCommandError(
+		"Couldn't get a list of feeds from the database. Try again later",
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	if len(document.Body) == 0 {
+		return commands.NewError("Can't export, you haven't added any feeds yet")
+	}
+
+	encoded, err := xml.MarshalIndent(document, "", "  ")
+	if commandError = commands.CreateCommandError(
+		"Couldn't write the feeds as OPML",
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	response <- commands.MessageResponse{
+		ChannelID: channelID,
+		Message:   fmt.Sprintf("Here are the %d feeds in this server", len(document.Body)),
+		File: &discordgo.File{
+			Name:        "feeds.opml",
+			ContentType: "text/x-opml",
+			Reader:      bytes.NewReader(append([]byte(xml.Header), encoded...)),
+		},
+	}
+
+	return nil
+}
+
+// selectOPML describes every feed in the server, along with the channels it is posted to.
+func selectOPML(ctx context.Context, dbPool *pgxpool.Pool, guildID string) (*opml, error) {
+	rows, err := dbPool.Query(ctx, opmlExportList, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	document := &opml{
+		Version: "2.0",
+		Title:   "BirbBot feeds",
+		Created: time.Now().UTC().Format(time.RFC1123Z),
+	}
+
+	for rows.Next() {
+		var title, url string
+
+		var channels []string
+		if err := rows.Scan(&title, &url, &channels); err != nil {
+			return nil, err
+		}
+
+		document.Body = append(document.Body, outline{
+			Type:     "rss",
+			Text:     title,
+			Title:    title,
+			XMLURL:   url,
+			Channels: strings.Join(channels, ","),
+		})
+	}
+
+	return document, rows.Err()
+}
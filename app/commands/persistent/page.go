@@ -0,0 +1,58 @@
+package persistent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+)
+
+const pageChangedTitle = "%s changed"
+
+var errSelectorMissed = errors.New("the selector doesn't match anything on the page")
+
+// ParsePage reads a watched page as a feed with a single item: whatever the selector matches.
+// The item's GUID is a hash of the text it matched, so any change to the text is a new item.
+// A page the selector no longer matches is a failure, since the page has most likely been redesigned.
+func ParsePage(pageURL, selector string) DocumentParser {
+	return func(body []byte) (*gofeed.Feed, error) {
+		document, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		matches := document.Find(selector)
+		if matches.Length() == 0 {
+			return nil, fmt.Errorf("%w: %s", errSelectorMissed, selector)
+		}
+
+		content := matches.Map(func(_ int, match *goquery.Selection) string {
+			return strings.Join(strings.Fields(match.Text()), " ")
+		})
+		text := strings.TrimSpace(strings.Join(content, "\n"))
+
+		title := strings.TrimSpace(document.Find("title").First().Text())
+		if len(title) == 0 {
+			title = pageURL
+		}
+
+		fetched := time.Now().UTC()
+
+		return &gofeed.Feed{
+			Title: title,
+			Link:  pageURL,
+			Items: []*gofeed.Item{{
+				Title:           fmt.Sprintf(pageChangedTitle, title),
+				Link:            pageURL,
+				Description:     text,
+				GUID:            hashKey(text),
+				Published:       fetched.Format(time.RFC3339),
+				PublishedParsed: &fetched,
+			}},
+		}, nil
+	}
+}
@@ -0,0 +1,223 @@
+package persistent
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/mmcdole/gofeed/json"
+)
+
+// durationKey is where the duration of a JSON Feed attachment is kept, since items have nowhere else for it.
+const durationKey = "duration"
+
+// Episode is what a podcast feed (or a JSON Feed with attachments) says about one of its items.
+type Episode struct {
+	// Number and Season are empty if the feed doesn't number its episodes
+	Number string
+	Season string
+	// Duration is zero if the feed didn't say how long the episode is
+	Duration time.Duration
+	Artwork  string
+	// Media is the audio or video of the episode
+	Media *gofeed.Enclosure
+}
+
+// itemEpisode describes the item as an episode, or returns nil if it has no audio or video to play.
+func itemEpisode(item *gofeed.Item) *Episode {
+	media := mediaEnclosure(item)
+	if media == nil {
+		return nil
+	}
+
+	episode := &Episode{Media: media, Duration: parseDuration(item.Custom[durationKey])}
+
+	if itunes := item.ITunesExt; itunes != nil {
+		episode.Number = strings.TrimSpace(itunes.Episode)
+		episode.Season = strings.TrimSpace(itunes.Season)
+		episode.Artwork = strings.TrimSpace(itunes.Image)
+
+		if duration := parseDuration(itunes.Duration); duration != 0 {
+			episode.Duration = duration
+		}
+	}
+
+	if len(episode.Artwork) == 0 && item.Image != nil {
+		episode.Artwork = item.Image.URL
+	}
+
+	return episode
+}
+
+// mediaEnclosure finds the first enclosure that is audio or video.
+func mediaEnclosure(item *gofeed.Item) *gofeed.Enclosure {
+	for _, enclosure := range item.Enclosures {
+		if isMedia(enclosure.Type) && len(enclosure.URL) != 0 {
+			return enclosure
+		}
+	}
+
+	return nil
+}
+
+func isMedia(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "audio/") || strings.HasPrefix(mimeType, "video/")
+}
+
+// Label names the episode the way a podcast app would, e.g. "S2E5" or "Episode 5".
+func (e Episode) Label() string {
+	switch {
+	case len(e.Number) == 0:
+		return ""
+	case len(e.Season) == 0:
+		return "Episode " + e.Number
+	default:
+		return fmt.Sprintf("S%sE%s", e.Season, e.Number)
+	}
+}
+
+// FormattedDuration is the duration as a clock would show it, or empty if it isn't known.
+func (e Episode) FormattedDuration() string {
+	if e.Duration == 0 {
+		return ""
+	}
+
+	hours := int(e.Duration / time.Hour)
+	minutes := int(e.Duration % time.Hour / time.Minute)
+	seconds := int(e.Duration % time.Minute / time.Second)
+
+	if hours != 0 {
+		return fmt.Sprintf("%d:%02d:%02d", hours, minutes, seconds)
+	}
+
+	return fmt.Sprintf("%d:%02d", minutes, seconds)
+}
+
+// Summary is a line describing the episode, e.g. "S2E5 · 1:02:03 · audio/mpeg".
+func (e Episode) Summary() string {
+	parts := []string{}
+
+	for _, part := range []string{e.Label(), e.FormattedDuration(), e.Media.Type} {
+		if len(part) != 0 {
+			parts = append(parts, part)
+		}
+	}
+
+	return strings.Join(parts, " · ")
+}
+
+// parseDuration parses an iTunes duration, which is either seconds or [[HH:]MM:]SS.
+// Anything else is treated as unknown.
+func parseDuration(text string) time.Duration {
+	text = strings.TrimSpace(text)
+	if len(text) == 0 {
+		return 0
+	}
+
+	parts := strings.Split(text, ":")
+	if len(parts) > 3 {
+		return 0
+	}
+
+	var duration time.Duration
+
+	for _, part := range parts {
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil || value < 0 {
+			return 0
+		}
+
+		duration = duration*60 + time.Duration(value*float64(time.Second))
+	}
+
+	return duration
+}
+
+// findEpisode picks the episode with the given number, or the newest episode if no number was given.
+func findEpisode(items []*gofeed.Item, number string) (*gofeed.Item, *Episode) {
+	var newest *gofeed.Item
+
+	var newestEpisode *Episode
+
+	for _, item := range items {
+		episode := itemEpisode(item)
+		if episode == nil {
+			continue
+		}
+
+		if len(number) != 0 {
+			if episode.Number == number {
+				return item, episode
+			}
+
+			continue
+		}
+
+		// Feeds list the newest first, unless the dates say otherwise
+		if newest == nil || isNewer(item, newest) {
+			newest, newestEpisode = item, episode
+		}
+	}
+
+	return newest, newestEpisode
+}
+
+func isNewer(item, than *gofeed.Item) bool {
+	date, thanDate := itemDate(item), itemDate(than)
+
+	return date != nil && thanDate != nil && date.After(*thanDate)
+}
+
+// jsonTranslator keeps what JSON Feed attachments say that the default translator loses.
+// The default translator puts an attachment's duration where its size should be, and drops its duration.
+type jsonTranslator struct {
+	gofeed.DefaultJSONTranslator
+}
+
+func (t *jsonTranslator) Translate(feed interface{}) (*gofeed.Feed, error) {
+	translated, err := t.DefaultJSONTranslator.Translate(feed)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonFeed, isJSON := feed.(*json.Feed)
+	if !isJSON || len(jsonFeed.Items) != len(translated.Items) {
+		return translated, nil
+	}
+
+	for i, jsonItem := range jsonFeed.Items {
+		if jsonItem.Attachments == nil {
+			continue
+		}
+
+		item := translated.Items[i]
+
+		for j, attachment := range *jsonItem.Attachments {
+			if j >= len(item.Enclosures) {
+				break
+			}
+
+			item.Enclosures[j].Length = ""
+			if attachment.SizeInBytes > 0 {
+				item.Enclosures[j].Length = strconv.FormatInt(attachment.SizeInBytes, 10)
+			}
+
+			if attachment.DurationInSeconds <= 0 || !isMedia(attachment.MimeType) {
+				continue
+			}
+
+			if item.Custom == nil {
+				item.Custom = map[string]string{}
+			}
+
+			// The first audio or video is the episode, any others are alternate formats of it
+			if _, found := item.Custom[durationKey]; !found {
+				item.Custom[durationKey] = strconv.FormatInt(attachment.DurationInSeconds, 10)
+			}
+		}
+	}
+
+	return translated, nil
+}
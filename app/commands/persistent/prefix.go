@@ -0,0 +1,189 @@
+package persistent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"quozlet.net/birbbot/app/commands"
+)
+
+// DefaultPrefix that commands must begin with to be recognized, unless a server has set its own.
+const DefaultPrefix = "!"
+
+const maxPrefixLength = 5
+
+const (
+	prefixUpsert string = "INSERT INTO Prefixes(GuildID, Prefix) VALUES ($1, $2) " +
+		"ON CONFLICT (GuildID) DO UPDATE SET Prefix=excluded.Prefix"
+	prefixSelect string = "SELECT Prefix FROM Prefixes WHERE GuildID = $1"
+	prefixDelete string = "DELETE FROM Prefixes WHERE GuildID = $1"
+)
+
+var (
+	prefixCache = map[string]string{}
+	prefixMutex = &sync.RWMutex{}
+)
+
+// Prefix is a Command to view or change the prefix used to invoke commands in a server.
+type Prefix struct{}
+
+// Check returns nil, the Prefixes table is created by migrations.
+func (p Prefix) Check(dbPool *pgxpool.Pool) error {
+	return nil
+}
+
+// ProcessMessage will either report, set, or reset the prefix for the server the message was sent in.
+func (p Prefix) ProcessMessage(
+	ctx context.Context,
+	response chan<- commands.MessageResponse,
+	m *discordgo.MessageCreate,
+	dbPool *pgxpool.Pool,
+) *commands.CommandError {
+	if len(m.GuildID) == 0 {
+		return commands.NewError("Direct messages always use the default prefix " +
+			fmt.Sprintf("`%s`", DefaultPrefix))
+	}
+
+	message := strings.Fields(m.Content)[1:]
+	if len(message) == 0 {
+		response <- commands.MessageResponse{
+			ChannelID: m.ChannelID,
+			Message:   fmt.Sprintf("The prefix for this server is `%s`", LookupPrefix(ctx, dbPool, m.GuildID)),
+		}
+
+		return nil
+	}
+
+	switch message[0] {
+	case "set":
+		return setPrefix(ctx, response, m.ChannelID, m.GuildID, message[1:], dbPool)
+	case "reset":
+		return resetPrefix(ctx, response, m.ChannelID, m.GuildID, dbPool)
+	default:
+		return commands.NewError(fmt.Sprintf("Not sure what `%s` means, see `help prefix`", message[0]))
+	}
+}
+
+// Privileged reports that changing the prefix requires elevated permissions, but viewing it does not.
+func (p Prefix) Privileged(args []string) bool {
+	return len(args) != 0 && (args[0] == "set" || args[0] == "reset")
+}
+
+// CommandList returns a list of aliases for the Prefix Command.
+func (p Prefix) CommandList() []string {
+	return []string{"prefix"}
+}
+
+// Help returns the help message for the Prefix Command.
+func (p Prefix) Help() string {
+	return "`prefix` shows the prefix commands must start with in this server\n" +
+		fmt.Sprintf("- `prefix set <value>` changes the prefix (at most %d characters, no spaces)\n", maxPrefixLength) +
+		fmt.Sprintf("- `prefix reset` changes the prefix back to `%s`\n\n", DefaultPrefix) +
+		"_Mentioning the bot (e.g. `@BirbBot help`) always works, whatever the prefix_"
+}
+
+func setPrefix(
+	ctx context.Context,
+	response chan<- commands.MessageResponse,
+	channelID string,
+	guildID string,
+	args []string,
+	dbPool *pgxpool.Pool,
+) *commands.CommandError {
+	if len(args) != 1 {
+		return commands.NewError("Provide exactly one prefix to use (no spaces)")
+	}
+
+	if utf8.RuneCountInString(args[0]) > maxPrefixLength {
+		return commands.NewError(fmt.Sprintf("`%s` is too long, a prefix can be at most %d characters",
+			args[0],
+			maxPrefixLength))
+	}
+
+	tag, err := dbPool.Exec(ctx, prefixUpsert, guildID, args[0])
+	if commandError := commands.CreateCommandError(
+		"Couldn't save the new prefix, the old one is still in use",
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	log.Printf("Prefix: %s (actually set %s for %s)", tag, args[0], guildID)
+	cachePrefix(guildID, args[0])
+	response <- commands.MessageResponse{
+		ChannelID: channelID,
+		Message:   fmt.Sprintf("Got it! Commands in this server now start with `%s`", args[0]),
+	}
+
+	return nil
+}
+
+func resetPrefix(
+	ctx context.Context,
+	response chan<- commands.MessageResponse,
+	channelID string,
+	guildID string,
+	dbPool *pgxpool.Pool,
+) *commands.CommandError {
+	tag, err := dbPool.Exec(ctx, prefixDelete, guildID)
+	if commandError := commands.CreateCommandError(
+		"Couldn't reset the prefix, the old one is still in use",
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	log.Printf("Prefix: %s (actually reset for %s)", tag, guildID)
+	cachePrefix(guildID, DefaultPrefix)
+	response <- commands.MessageResponse{
+		ChannelID: channelID,
+		Message:   fmt.Sprintf("Got it! Commands in this server now start with `%s`", DefaultPrefix),
+	}
+
+	return nil
+}
+
+// LookupPrefix returns the prefix for a server, falling back to the DefaultPrefix.
+// Prefixes are cached after the first lookup, so this is cheap to call for every message.
+func LookupPrefix(ctx context.Context, dbPool *pgxpool.Pool, guildID string) string {
+	if len(guildID) == 0 {
+		return DefaultPrefix
+	}
+
+	prefixMutex.RLock()
+	prefix, cached := prefixCache[guildID]
+	prefixMutex.RUnlock()
+
+	if cached {
+		return prefix
+	}
+
+	if err := dbPool.QueryRow(ctx, prefixSelect, guildID).Scan(&prefix); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			// Don't cache, the server may have set a prefix
+			log.Println(err)
+
+			return DefaultPrefix
+		}
+
+		prefix = DefaultPrefix
+	}
+
+	cachePrefix(guildID, prefix)
+
+	return prefix
+}
+
+func cachePrefix(guildID string, prefix string) {
+	prefixMutex.Lock()
+	prefixCache[guildID] = prefix
+	prefixMutex.Unlock()
+}
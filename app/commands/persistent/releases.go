@@ -0,0 +1,103 @@
+package persistent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// PrereleaseCategory is the category of releases GitHub marks as prereleases.
+// Their titles say so too, so title filters can leave them out.
+const PrereleaseCategory = "prerelease"
+
+const githubReleasesURL = "https://api.github.com/repos/%s/releases"
+
+// GitHub repositories are named owner/repo, with only letters, numbers, and some punctuation.
+var githubRepository = regexp.MustCompile(`^[A-Za-z0-9-]+/[A-Za-z0-9._-]+$`)
+
+var errInvalidRepository = errors.New("not a GitHub repository")
+
+// githubRelease is the subset of a GitHub release that is posted.
+type githubRelease struct {
+	TagName     string     `json:"tag_name"`
+	Name        string     `json:"name"`
+	HTMLURL     string     `json:"html_url"`
+	Body        string     `json:"body"`
+	Draft       bool       `json:"draft"`
+	Prerelease  bool       `json:"prerelease"`
+	PublishedAt *time.Time `json:"published_at"`
+	Author      struct {
+		Login string `json:"login"`
+	} `json:"author"`
+}
+
+// githubRepositoryName finds the owner/repo name in either the name itself, or a link to the repository.
+func githubRepositoryName(text string) (string, error) {
+	name := strings.TrimSuffix(strings.TrimSpace(text), "/")
+
+	if parsed, err := url.Parse(name); err == nil && strings.EqualFold(parsed.Host, "github.com") {
+		parts := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+		if len(parts) >= 2 {
+			name = parts[0] + "/" + strings.TrimSuffix(parts[1], ".git")
+		}
+	}
+
+	if !githubRepository.MatchString(name) {
+		return "", fmt.Errorf("%w: %s", errInvalidRepository, text)
+	}
+
+	return name, nil
+}
+
+// ParseReleases reads the GitHub API's list of a repository's releases as a feed.
+// Drafts are only visible with access to the repository, so they are never listed, but are left out anyway.
+func ParseReleases(repository string) DocumentParser {
+	return func(body []byte) (*gofeed.Feed, error) {
+		releases := []githubRelease{}
+		if err := json.Unmarshal(body, &releases); err != nil {
+			return nil, err
+		}
+
+		feed := &gofeed.Feed{
+			Title: repository + " releases",
+			Link:  "https://github.com/" + repository + "/releases",
+			Items: []*gofeed.Item{},
+		}
+
+		for _, release := range releases {
+			if release.Draft {
+				continue
+			}
+
+			title := release.Name
+			if len(strings.TrimSpace(title)) == 0 {
+				title = release.TagName
+			}
+
+			item := &gofeed.Item{
+				Title:       title,
+				Link:        release.HTMLURL,
+				Description: release.Body,
+				// Tags are what a release is known by, even if it is deleted and published again
+				GUID:            release.TagName,
+				PublishedParsed: release.PublishedAt,
+				Author:          &gofeed.Person{Name: release.Author.Login},
+			}
+
+			if release.Prerelease {
+				item.Title = fmt.Sprintf("%s (%s)", title, PrereleaseCategory)
+				item.Categories = []string{PrereleaseCategory}
+			}
+
+			feed.Items = append(feed.Items, item)
+		}
+
+		return feed, nil
+	}
+}
@@ -2,58 +2,64 @@ package persistent
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/url"
-	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/k3a/html2text"
 	"github.com/mmcdole/gofeed"
 	"quozlet.net/birbbot/app/commands"
+	"quozlet.net/birbbot/app/commands/audio"
 )
 
 const (
 	invalidRSSIDErrorMsg = "Hey, so, uh, I need an _ID_, a number. %s is not a number"
 	missingRSSIDErrorMsg = "I understood the ID, but the database says it's invalid. Can you double-check?"
+	guildOnlyErrorMsg    = "Feeds belong to a server, so this only works in one"
 )
 
-// TODO: Finalize schema with RSS/Sub combined w/ proper handling of CASCADE etc.
 const (
-	rssTableDefinition string = "CREATE TABLE IF NOT EXISTS Feeds " +
-		"(ID SERIAL PRIMARY KEY, Title TEXT NOT NULL, URL TEXT UNIQUE NOT NULL, LastItems JSONB NOT NULL)"
-	rssNewFeed string = "INSERT INTO Feeds(Title, URL, LastItems) VALUES ($1, $2, $3) ON CONFLICT (URL) DO NOTHING"
-	rssList    string = "SELECT ID, Title, URL, LastItems FROM Feeds ORDER BY ID"
+	rssNewFeed string = "INSERT INTO Feeds(GuildID, Title, URL) VALUES ($1, $2, $3) " +
+		"ON CONFLICT (GuildID, Kind, URL, Selector) DO NOTHING RETURNING ID"
+	rssList        string = "SELECT ID, Title, URL, Kind, Selector, Dead FROM Feeds WHERE GuildID = $1 ORDER BY ID"
+	rssGuildSelect string = "SELECT Title, URL, Kind, Selector FROM Feeds WHERE ID = $1 AND GuildID = $2"
+	// Removing a feed removes its subscriptions, and unapplies its filters
+	rssDelete       string = "DELETE FROM Feeds WHERE ID = $1 AND GuildID = $2"
+	rssCountSubs    string = "SELECT COUNT(*) FROM Subscriptions WHERE FeedID = $1"
+	feedRemovedMsg  string = "Removed **%s**, along with its subscriptions"
+	feedRemoveAsk   string = "Remove **%s** (%s)? It is posted to %d channels, which will stop"
+	removeCancelMsg string = "Okay, I left it alone"
+	feedRevivedMsg  string = "I'll start checking **%s** again"
+	feedAliveMsg    string = "**%s** is still being checked, there's nothing to revive"
+	// The rest of the new items are posted by running it again
+	latestMoreItemsMsg string = "+%d more new items from **%s**, use `rss latest %d` again to post them"
 )
 
-// RSSSelect an RSS feed by ID.
-const RSSSelect string = "SELECT Title, URL, LastItems FROM Feeds WHERE ID = $1"
+const postedItemsWriteTimeout = 10 * time.Second
 
-// RSSUpdateLastItem with a new hash.
-const RSSUpdateLastItem string = "UPDATE Feeds SET LastItems = $1 WHERE ID = $2"
+// rss latest posts as many new items at once as a new subscription does.
+const latestItemLimit = 5
 
 // RSS is a command to fetch an RSS feed for validation.
 type RSS struct{}
 
-// Check returns nil.
+// Check returns nil, the Feeds table is created by migrations.
 func (r RSS) Check(dbPool *pgxpool.Pool) error {
-	tag, err := dbPool.Exec(context.Background(), rssTableDefinition)
-	if err != nil {
-		return err
-	}
-
-	log.Printf("RSS: %s", tag)
-
 	return nil
 }
 
 // ProcessMessage attempts to parse the first argument as a URL to an RSS feed,
 // then fetch the first argument. If any step fails, an error is returned.
 func (r RSS) ProcessMessage(
+	ctx context.Context,
 	response chan<- commands.MessageResponse,
 	m *discordgo.MessageCreate,
 	dbPool *pgxpool.Pool,
@@ -66,31 +72,143 @@ func (r RSS) ProcessMessage(
 			" We weren't even testing for that")
 	}
 
+	if len(m.GuildID) == 0 {
+		return commands.NewError(guildOnlyErrorMsg)
+	}
+
 	message := splitContent[1:]
 
 	switch message[0] {
 	case "list":
-		return listFeeds(response, m.ChannelID, dbPool)
+		return listFeeds(ctx, response, m.ChannelID, m.GuildID, dbPool)
 
 	case "find":
-		return findFeedByID(response, m.ChannelID, message, dbPool)
+		return findFeedByID(ctx, response, m.ChannelID, m.GuildID, message, dbPool)
 
 	case "latest":
-		return fetchLatest(response, m.ChannelID, message, dbPool)
+		return fetchLatest(ctx, response, m.ChannelID, m.GuildID, message, dbPool)
+
+	case "remove":
+		return removeFeed(ctx, response, m, message, dbPool)
+
+	case "revive":
+		return reviveFeed(ctx, response, m.ChannelID, m.GuildID, message, dbPool)
+
+	case "import":
+		return importFeeds(ctx, response, m, dbPool)
+
+	case "export":
+		return exportFeeds(ctx, response, m.ChannelID, m.GuildID, dbPool)
 
 	default:
-		return storeNewFeed(response, m.ChannelID, message[0], dbPool)
+		return storeNewFeed(ctx, response, m, message[0], dbPool)
 	}
 }
 
+// Playable reports whether the RSS Command was asked to play an episode, which is handed to the audio pipeline.
+func (r RSS) Playable(args []string) bool {
+	return len(args) != 0 && args[0] == "play"
+}
+
+// ProcessAudio finds the episode of a podcast to play, the newest unless an episode number was given.
+func (r RSS) ProcessAudio(
+	ctx context.Context,
+	response chan<- commands.MessageResponse,
+	m *discordgo.MessageCreate,
+	dbPool *pgxpool.Pool,
+) (*audio.Data, *commands.CommandError) {
+	var commandError *commands.CommandError
+
+	if len(m.GuildID) == 0 {
+		return nil, commands.NewError(guildOnlyErrorMsg)
+	}
+
+	args := strings.Fields(m.Content)[1:]
+	if len(args) == 1 {
+		return nil, commands.NewError("Play what? I need the ID of a podcast's feed")
+	}
+
+	id, err := strconv.ParseInt(args[1], 0, 64)
+
+	if commandError = commands.CreateCommandError(
+		fmt.Sprintf(invalidRSSIDErrorMsg, args[1]),
+		err,
+	); commandError != nil {
+		return nil, commandError
+	}
+
+	info, err := selectFeedDB(ctx, dbPool, id, m.GuildID)
+
+	if commandError = commands.CreateCommandError(
+		missingRSSIDErrorMsg,
+		err,
+	); commandError != nil {
+		return nil, commandError
+	}
+
+	if info.Kind != RSSFeed {
+		return nil, commands.NewError(fmt.Sprintf("**%s** isn't a feed, so it doesn't have episodes to play", info.Title))
+	}
+
+	feedURL, err := url.Parse(info.URL)
+
+	if commandError = commands.CreateCommandError(
+		"This isn't good. Somehow an invalid feed URL was saved into the database for this ID",
+		err,
+	); commandError != nil {
+		return nil, commandError
+	}
+
+	feed, err := RefreshFeed(ctx, feedURL)
+
+	if commandError = commands.CreateCommandError(
+		"Tried to fetch the feed, but some error occurred reading it",
+		err,
+	); commandError != nil {
+		return nil, commandError
+	}
+
+	number := ""
+	if len(args) > 2 {
+		number = args[2]
+	}
+
+	item, episode := findEpisode(feed.Items, number)
+
+	switch {
+	case episode == nil && len(number) != 0:
+		return nil, commands.NewError(fmt.Sprintf("**%s** doesn't have an episode %s", info.Title, number))
+	case episode == nil:
+		return nil, commands.NewError(fmt.Sprintf("**%s** doesn't have any episodes I can play", info.Title))
+	}
+
+	mediaURL, err := url.Parse(episode.Media.URL)
+
+	if commandError = commands.CreateCommandError(
+		"The feed links to the episode with an invalid URL, so I can't play it",
+		err,
+	); commandError != nil {
+		return nil, commandError
+	}
+
+	title := html2text.HTML2Text(item.Title)
+	if label := episode.Label(); len(label) != 0 {
+		title = label + ": " + title
+	}
+
+	return audio.PlayURL(mediaURL, response, m.ChannelID, fmt.Sprintf("%s - %s", info.Title, title))
+}
+
 func listFeeds(
+	ctx context.Context,
 	response chan<- commands.MessageResponse,
 	channelID string,
+	guildID string,
 	dbPool *pgxpool.Pool,
 ) *commands.CommandError {
 	var commandError *commands.CommandError
 
-	feeds, err := selectAllFeedDB(dbPool)
+	feeds, err := selectAllFeedDB(ctx, dbPool, guildID)
 
 	if commandError = commands.CreateCommandError(
 		"Couldn't get a list of feeds from the database. "+
@@ -103,7 +221,20 @@ func listFeeds(
 	builder := strings.Builder{}
 
 	for _, info := range feeds {
-		builder.WriteString(fmt.Sprintf("ID: %d | %s (%s)\n", info.ID, info.Title, info.URL))
+		builder.WriteString(fmt.Sprintf("ID: %d | %s (%s)", info.ID, info.Title, info.URL))
+
+		switch info.Kind {
+		case PageWatcher:
+			builder.WriteString(fmt.Sprintf(" - watching `%s`", info.Selector))
+		case ReleasesWatcher:
+			builder.WriteString(" - watching releases")
+		}
+
+		if info.Dead {
+			builder.WriteString(" - stopped checking after it kept failing")
+		}
+
+		builder.WriteString("\n")
 	}
 
 	if builder.Len() == 0 {
@@ -118,8 +249,10 @@ func listFeeds(
 }
 
 func findFeedByID(
+	ctx context.Context,
 	response chan<- commands.MessageResponse,
 	channelID string,
+	guildID string,
 	args []string,
 	dbPool *pgxpool.Pool,
 ) *commands.CommandError {
@@ -138,7 +271,7 @@ func findFeedByID(
 		return commandError
 	}
 
-	info, err := selectFeedDB(dbPool, id)
+	info, err := selectFeedDB(ctx, dbPool, id, guildID)
 
 	if commandError = commands.CreateCommandError(
 		missingRSSIDErrorMsg,
@@ -154,9 +287,64 @@ func findFeedByID(
 	return nil
 }
 
+func reviveFeed(
+	ctx context.Context,
+	response chan<- commands.MessageResponse,
+	channelID string,
+	guildID string,
+	args []string,
+	dbPool *pgxpool.Pool,
+) *commands.CommandError {
+	var commandError *commands.CommandError
+
+	if len(args) == 1 {
+		return commands.NewError("Which feed? I need an ID to bring it back")
+	}
+
+	id, err := strconv.ParseInt(args[1], 0, 64)
+
+	if commandError = commands.CreateCommandError(
+		fmt.Sprintf(invalidRSSIDErrorMsg, args[1]),
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	info, err := selectFeedDB(ctx, dbPool, id, guildID)
+
+	if commandError = commands.CreateCommandError(
+		missingRSSIDErrorMsg,
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	revived, err := ReviveFeed(ctx, dbPool, id, guildID)
+
+	if commandError = commands.CreateCommandError(
+		"Couldn't revive the feed, try again later",
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	message := fmt.Sprintf(feedRevivedMsg, info.Title)
+	if !revived {
+		message = fmt.Sprintf(feedAliveMsg, info.Title)
+	}
+	response <- commands.MessageResponse{
+		ChannelID: channelID,
+		Message:   message,
+	}
+
+	return nil
+}
+
 func fetchLatest(
+	ctx context.Context,
 	response chan<- commands.MessageResponse,
 	channelID string,
+	guildID string,
 	args []string,
 	dbPool *pgxpool.Pool,
 ) *commands.CommandError {
@@ -175,7 +363,7 @@ func fetchLatest(
 		return commandError
 	}
 
-	info, err := selectFeedDB(dbPool, id)
+	info, err := selectFeedDB(ctx, dbPool, id, guildID)
 
 	if commandError = commands.CreateCommandError(
 		missingRSSIDErrorMsg,
@@ -184,97 +372,193 @@ func fetchLatest(
 		return commandError
 	}
 
-	url, err := url.Parse(info.URL)
+	feed, err := refreshFeedInfo(ctx, info)
 
 	if commandError = commands.CreateCommandError(
-		"This isn't good. Somehow an invalid feed URL was saved into the database for this ID",
+		"Tried to fetch the feed, but some error occurred reading it",
 		err,
 	); commandError != nil {
 		return commandError
 	}
 
-	feed, err := RefreshFeed(url)
+	filters, err := FetchFilters(ctx, id, dbPool)
 
 	if commandError = commands.CreateCommandError(
-		"Tried to fetch the feed, but some error occurred reading it",
+		"Couldn't look up the filters for this feed, so I didn't post anything",
 		err,
 	); commandError != nil {
 		return commandError
 	}
 
-	urls, haveNewFeeds := deduplicateItems(feed.Items, FetchRegex(id, dbPool), info, response, channelID)
+	seen, err := SelectSeen(ctx, dbPool, id, feedChannel, ReduceItem(feed.Items, filters))
+
+	if commandError = commands.CreateCommandError(
+		"Couldn't look up what has already been posted, so I didn't post anything",
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	posted, haveNewFeeds := deduplicateItems(feed.Items, filters, info, seen, response, channelID)
 
 	if !haveNewFeeds {
 		return nil
 	}
 
-	if commandError = updateLastItem(dbPool, urls, info.LastItems, id); commandError != nil {
+	if commandError = markPosted(dbPool, posted, id); commandError != nil {
 		return commandError
 	}
 
 	return nil
 }
 
-func storeNewFeed(
+func removeFeed(
+	ctx context.Context,
 	response chan<- commands.MessageResponse,
-	channelID string,
-	userMsg string,
+	m *discordgo.MessageCreate,
+	args []string,
 	dbPool *pgxpool.Pool,
 ) *commands.CommandError {
 	var commandError *commands.CommandError
 
-	url, err := url.Parse(userMsg)
+	if len(args) == 1 {
+		return commands.NewError("Which feed? Provide an ID from `rss list`")
+	}
+
+	id, err := strconv.ParseInt(args[1], 0, 64)
 	if commandError = commands.CreateCommandError(
-		fmt.Sprintf("%s doesn't seem to be a valid URL", userMsg),
+		fmt.Sprintf(invalidRSSIDErrorMsg, args[1]),
 		err,
 	); commandError != nil {
 		return commandError
 	}
 
-	url.Scheme = "https"
-	feed, err := RefreshFeed(url)
+	info, err := selectFeedDB(ctx, dbPool, id, m.GuildID)
+	if commandError = commands.CreateCommandError(
+		missingRSSIDErrorMsg,
+		err,
+	); commandError != nil {
+		return commandError
+	}
 
+	var subscriptions int64
 	if commandError = commands.CreateCommandError(
-		"Tried to fetch the feed, but some error occurred reading it",
+		"Couldn't check what's subscribed to that feed, so I didn't remove it",
+		dbPool.QueryRow(ctx, rssCountSubs, id).Scan(&subscriptions),
+	); commandError != nil {
+		return commandError
+	}
+
+	if !commands.Confirm(ctx, response, m.ChannelID, m.Author.ID,
+		fmt.Sprintf(feedRemoveAsk, info.Title, info.URL, subscriptions),
+	) {
+		response <- commands.MessageResponse{
+			ChannelID: m.ChannelID,
+			Message:   removeCancelMsg,
+		}
+
+		return nil
+	}
+
+	tag, err := dbPool.Exec(ctx, rssDelete, id, m.GuildID)
+	if commandError = commands.CreateCommandError(
+		"Failed to remove that feed, it's still there",
 		err,
 	); commandError != nil {
 		return commandError
 	}
 
+	log.Printf("RSS: %s (actually removed %d from %s)", tag, id, m.GuildID)
+	response <- commands.MessageResponse{
+		ChannelID: m.ChannelID,
+		Message:   fmt.Sprintf(feedRemovedMsg, info.Title),
+	}
+
+	return nil
+}
+
+// storeNewFeed adds the feed at the URL, or the feed linked to by the page at the URL.
+// If the page links to several feeds, the user is asked which one to add.
+func storeNewFeed(
+	ctx context.Context,
+	response chan<- commands.MessageResponse,
+	m *discordgo.MessageCreate,
+	userMsg string,
+	dbPool *pgxpool.Pool,
+) *commands.CommandError {
+	feed, _, commandError := addFeed(ctx, dbPool, m.GuildID, userMsg, func(links []feedLink) int {
+		options := []string{}
+		for _, link := range links {
+			options = append(options, fmt.Sprintf("%s <%s>", link.title, link.url))
+		}
+
+		return commands.Choose(ctx, response, m.ChannelID, m.Author.ID,
+			"That page links to several feeds, which one should I add?", options)
+	})
+	if commandError != nil {
+		return commandError
+	}
+
+	response <- newFeedAckResponse(feed, m.ChannelID)
+
+	return nil
+}
+
+// addFeed fetches a feed and adds it to the server, with every item already in it treated as posted.
+// It reports whether the feed was added, since the server may already have it.
+func addFeed(
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	guildID string,
+	userMsg string,
+	choose chooseFeed,
+) (*gofeed.Feed, bool, *commands.CommandError) {
+	var commandError *commands.CommandError
+
+	feed, url, err := openFeed(ctx, userMsg, choose)
+	if commandError = feedOpenError(userMsg, err); commandError != nil {
+		return nil, false, commandError
+	}
+
 	feed.Title = html2text.HTML2Text(feed.Title)
-	response <- newFeedAckResponse(feed, channelID)
+	existing := ReduceItem(feed.Items, nil)
 
-	existing := make(map[string]struct{})
+	var id int64
 
-	for _, item := range ReduceItem(feed.Items, nil) {
-		existing[item.Description] = struct{}{}
+	err = dbPool.QueryRow(ctx, rssNewFeed, guildID, feed.Title, url).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("RSS: %s was already added for %s", url, guildID)
+
+		return feed, false, nil
 	}
 
-	tag, err := dbPool.Exec(context.Background(), rssNewFeed, html2text.HTML2Text(feed.Title), url.String(), existing)
 	if commandError = commands.CreateCommandError(
 		"Went to insert this feed into the database for later, and it didn't seem to like that."+
 			" Maybe provide a less spicy feed? Or try some Pepto-Bismol",
 		err,
 	); commandError != nil {
-		return commandError
+		return nil, false, commandError
 	}
 
-	log.Printf("RSS: %s (actually inserted row with Title %s, URL %s, and %d Existing items at insertion time)", tag,
-		html2text.HTML2Text(feed.Title),
-		url.String(),
+	if commandError = markPosted(dbPool, existing, id); commandError != nil {
+		return nil, false, commandError
+	}
+
+	log.Printf("RSS: inserted row %d for %s with Title %s, URL %s, and %d Existing items at insertion time",
+		id,
+		guildID,
+		feed.Title,
+		url,
 		len(existing))
 
-	return nil
+	return feed, true, nil
 }
 
-func updateLastItem(dbPool *pgxpool.Pool, urls, lastItems map[string]struct{}, id int64) *commands.CommandError {
-	tag, err := dbPool.Exec(context.Background(), RSSUpdateLastItem, func() map[string]struct{} {
-		for desc := range lastItems {
-			urls[desc] = struct{}{}
-		}
+func markPosted(dbPool *pgxpool.Pool, items []RSSInfo, id int64) *commands.CommandError {
+	ctx, cancel := PostedItemsContext()
+	defer cancel()
 
-		return urls
-	}(), id)
+	inserted, err := MarkSeen(ctx, dbPool, id, feedChannel, items)
 	if commandError := commands.CreateCommandError(
 		"Internal errors."+
 			" Couldn't save these new items as posted."+
@@ -284,7 +568,7 @@ func updateLastItem(dbPool *pgxpool.Pool, urls, lastItems map[string]struct{}, i
 		return commandError
 	}
 
-	log.Printf("RSS: %s (actually inserted %d items for %d)", tag, len(urls), id)
+	log.Printf("RSS: inserted %d items for %d", inserted, id)
 
 	return nil
 }
@@ -305,6 +589,31 @@ func newFeedAckResponse(feed *gofeed.Feed, channelID string) commands.MessageRes
 	}
 }
 
+// Timeout allows importing a lot of feeds, each of which is fetched.
+func (r RSS) Timeout() time.Duration {
+	return 10 * time.Minute
+}
+
+// Permission reports the permission the RSS Command requires by default.
+// Feeds are posted into channels, so adding (or removing) them requires being able to manage channels.
+func (r RSS) Permission() int64 {
+	return discordgo.PermissionManageChannels
+}
+
+// Permissioned reports whether the arguments change the feeds, rather than only reading them.
+func (r RSS) Permissioned(args []string) bool {
+	if len(args) == 0 {
+		return true
+	}
+
+	switch args[0] {
+	case "list", "find", "latest", "play", "export":
+		return false
+	default:
+		return true
+	}
+}
+
 // CommandList returns a list of aliases for the RSS Command.
 func (r RSS) CommandList() []string {
 	return []string{"rss"}
@@ -313,33 +622,46 @@ func (r RSS) CommandList() []string {
 // Help returns the help message for the RSS Command.
 func (r RSS) Help() string {
 	return "Subscribes to an RSS feed\n" +
-		"- `rss list` lists all subscribed RSS feeds\n" +
+		"- `rss <url>` adds a feed to this server, or the feed a web page links to (asking which, if there are several)\n" +
+		"- `rss list` lists all RSS feeds added in this server\n" +
 		"- `rss find <id>` finds an RSS feed by it's numerical ID\n" +
-		"- `rss latest <id>` re-fetches the latest element of the feed and (if it hasn't already been posted) posts it"
+		fmt.Sprintf("- `rss latest <id>` re-fetches the feed and posts the items that haven't already been posted "+
+			"(up to %d at once)\n", latestItemLimit) +
+		"- `rss remove <id>` removes the feed, and every subscription to it (after you confirm)\n" +
+		"- `rss revive <id>` starts checking a feed again, after it failed too many times in a row\n" +
+		"- `rss import` adds every feed in an attached OPML file (exported from another reader)\n" +
+		"- `rss export` uploads an OPML file of the feeds in this server, and the channels they're posted to\n" +
+		"- `rss play <id> [episode]` plays the latest episode of a podcast (or the episode with that number) " +
+		"in your voice channel"
 }
 
 // RSSInfo contains the posted information for a RSS feed item.
 type RSSInfo struct {
 	Title       string
 	Description string
+	// Item is everything else the feed said about the item, used to render it
+	Item *gofeed.Item
+	// Keys are hashes identifying the item, the first is the one it is recorded as seen by
+	Keys []string
 }
 
 // ReduceItem reduces a list of RSS items to a list of titles and secondary text (usually URLs).
-func ReduceItem(items []*gofeed.Item, regex *regexp.Regexp) []RSSInfo {
+// Items that the filters don't allow are left out.
+func ReduceItem(items []*gofeed.Item, filters FilterSet) []RSSInfo {
 	infoItems := []RSSInfo{}
 
 	for _, item := range items {
+		if !filters.Allows(item) {
+			continue
+		}
+
 		rssInfo := RSSInfo{
-			Title: html2text.HTML2Text(item.Title),
-			Description: func() string {
-				if regex != nil {
-					return extractDescriptionForRegex(regex, item)
-				}
-
-				return extractDescription(item)
-			}(),
+			Title:       html2text.HTML2Text(item.Title),
+			Description: extractDescription(item),
+			Item:        item,
 		}
 		if rssInfo.Description != "" {
+			rssInfo.Keys = itemKeys(item, rssInfo.Description)
 			infoItems = append(infoItems, rssInfo)
 		}
 	}
@@ -347,9 +669,43 @@ func ReduceItem(items []*gofeed.Item, regex *regexp.Regexp) []RSSInfo {
 	return infoItems
 }
 
+// Chronological orders items from oldest to newest.
+// Items are sorted by their dates if every item has one, otherwise feeds are assumed to list the newest first.
+func Chronological(items []RSSInfo) []RSSInfo {
+	ordered := make([]RSSInfo, len(items))
+	for i, item := range items {
+		ordered[len(items)-1-i] = item
+	}
+
+	for _, item := range ordered {
+		if itemDate(item.Item) == nil {
+			return ordered
+		}
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return itemDate(ordered[i].Item).Before(*itemDate(ordered[j].Item))
+	})
+
+	return ordered
+}
+
+func itemDate(item *gofeed.Item) *time.Time {
+	if item.PublishedParsed != nil {
+		return item.PublishedParsed
+	}
+
+	return item.UpdatedParsed
+}
+
 func extractDescription(item *gofeed.Item) string {
 	secondary := item.Link
 	if len(secondary) == 0 {
+		// Podcasts often don't link anywhere but the episode itself
+		if media := mediaEnclosure(item); media != nil {
+			return media.URL
+		}
+
 		if len(item.Enclosures) != 0 {
 			return item.Enclosures[0].URL
 		}
@@ -364,87 +720,76 @@ func extractDescription(item *gofeed.Item) string {
 	return secondary
 }
 
-func extractDescriptionForRegex(regex *regexp.Regexp, item *gofeed.Item) string {
-	if regex.MatchString(item.Link) {
-		return regex.FindString(item.Link)
-	}
-
-	if len(item.Enclosures) != 0 && regex.MatchString(item.Enclosures[0].URL) {
-		return regex.FindString(item.Enclosures[0].URL)
-	}
-
-	if regex.MatchString(item.Description) {
-		return regex.FindString(item.Description)
-	}
-
-	if regex.MatchString(item.Content) {
-		return regex.FindString(item.Content)
-	}
-
-	return ""
-}
-
 func deduplicateItems(
 	feedItems []*gofeed.Item,
-	regex *regexp.Regexp,
+	filters FilterSet,
 	info *feedInfo,
+	seen map[string]struct{},
 	response chan<- commands.MessageResponse,
 	channelID string,
-) (map[string]struct{}, bool) {
-	items := ReduceItem(feedItems, regex)
-	urls := make(map[string]struct{})
-	haveNewFeeds := false
+) ([]RSSInfo, bool) {
+	items := Chronological(ReduceItem(feedItems, filters))
+	posted := []RSSInfo{}
+	unposted := 0
 
 	for _, item := range items {
-		_, contained := info.LastItems[item.Description]
-		if !contained {
-			haveNewFeeds = true
-			response <- commands.MessageResponse{
-				ChannelID: channelID,
-				Message: fmt.Sprintf("%s: **%s**\n%s",
-					info.Title,
-					item.Title,
-					item.Description),
-			}
-
-			urls[item.Description] = struct{}{}
+		if item.SeenIn(seen) {
+			continue
 		}
+
+		// Only as many as a new subscription posts at once, the rest are left for the next time
+		if len(posted) == latestItemLimit {
+			unposted++
+
+			continue
+		}
+
+		rendered := RenderItem(info.Title, item, EmbedStyle)
+		rendered.ChannelID = channelID
+		response <- rendered
+
+		posted = append(posted, item)
 	}
 
-	if !haveNewFeeds {
+	switch {
+	case len(posted) == 0:
 		response <- commands.MessageResponse{
 			ChannelID: channelID,
 			Message:   "Nothing new to report",
 		}
+	case unposted != 0:
+		response <- commands.MessageResponse{
+			ChannelID: channelID,
+			Message:   fmt.Sprintf(latestMoreItemsMsg, unposted, info.Title, info.ID),
+		}
 	}
 
-	return urls, haveNewFeeds
+	return posted, len(posted) != 0
 }
 
-// RefreshFeed fetches a given RSS feed.
-func RefreshFeed(url *url.URL) (*gofeed.Feed, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
-
-	fp := gofeed.NewParser()
-
-	return fp.ParseURLWithContext(url.String(), ctx)
+// PostedItemsContext is used to record items that have been posted, instead of the command's context.
+// If recording was cancelled (e.g. by shutting down) after the items were posted, they would be posted again.
+func PostedItemsContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), postedItemsWriteTimeout)
 }
 
 // SQL Helpers.
 
 type feedInfo struct {
-	ID        int64
-	Title     string
-	URL       string
-	LastItems map[string]struct{}
+	ID       int64
+	Title    string
+	URL      string
+	Kind     string
+	Selector string
+	Dead     bool
 }
 
-func selectAllFeedDB(dbPool *pgxpool.Pool) ([]*feedInfo, error) {
-	rows, err := dbPool.Query(context.Background(), rssList)
+func selectAllFeedDB(ctx context.Context, dbPool *pgxpool.Pool, guildID string) ([]*feedInfo, error) {
+	rows, err := dbPool.Query(ctx, rssList, guildID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	info := []*feedInfo{}
 
@@ -455,16 +800,20 @@ func selectAllFeedDB(dbPool *pgxpool.Pool) ([]*feedInfo, error) {
 
 		var url string
 
-		var lastItems map[string]struct{}
-		if err := rows.Scan(&id, &title, &url, &lastItems); err != nil {
+		var kind, selector string
+
+		var dead bool
+		if err := rows.Scan(&id, &title, &url, &kind, &selector, &dead); err != nil {
 			return nil, err
 		}
 
 		info = append(info, &feedInfo{
-			ID:        id,
-			Title:     title,
-			URL:       url,
-			LastItems: lastItems,
+			ID:       id,
+			Title:    title,
+			URL:      url,
+			Kind:     kind,
+			Selector: selector,
+			Dead:     dead,
 		})
 	}
 
@@ -475,15 +824,16 @@ func selectAllFeedDB(dbPool *pgxpool.Pool) ([]*feedInfo, error) {
 	return info, nil
 }
 
-func selectFeedDB(dbPool *pgxpool.Pool, id int64) (*feedInfo, error) {
+func selectFeedDB(ctx context.Context, dbPool *pgxpool.Pool, id int64, guildID string) (*feedInfo, error) {
 	var title string
 
 	var url string
 
-	var lastItems map[string]struct{}
-	if err := dbPool.QueryRow(context.Background(), RSSSelect, id).Scan(&title, &url, &lastItems); err != nil {
+	var kind, selector string
+
+	if err := dbPool.QueryRow(ctx, rssGuildSelect, id, guildID).Scan(&title, &url, &kind, &selector); err != nil {
 		return nil, err
 	}
 
-	return &feedInfo{ID: id, Title: title, URL: url, LastItems: lastItems}, nil
+	return &feedInfo{ID: id, Title: title, URL: url, Kind: kind, Selector: selector}, nil
 }
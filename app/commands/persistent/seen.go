@@ -0,0 +1,153 @@
+package persistent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/mmcdole/gofeed"
+)
+
+const (
+	// Seen items that are still in the feed are remembered for longer
+	seenSelect string = "UPDATE SeenItems SET LastSeen = now() " +
+		"WHERE FeedID = $1 AND Channel = $2 AND ItemHash = ANY($3) RETURNING ItemHash"
+	seenInsert string = "INSERT INTO SeenItems (FeedID, Channel, ItemHash) SELECT $1, $2, unnest($3::TEXT[]) " +
+		"ON CONFLICT DO NOTHING"
+	seenRefresh string = "UPDATE SeenItems SET LastSeen = now() " +
+		"WHERE FeedID = ANY($1) AND Channel = '' AND ItemHash = ANY($2)"
+	seenForget string = "DELETE FROM SeenItems WHERE LastSeen < $1"
+	// New subscriptions start from what the feed has seen, so they don't repost old items
+	seenCopy string = "INSERT INTO SeenItems (FeedID, Channel, ItemHash, FirstSeen, LastSeen) " +
+		"SELECT FeedID, $2, ItemHash, FirstSeen, LastSeen FROM SeenItems WHERE FeedID = $1 AND Channel = '' " +
+		"ON CONFLICT DO NOTHING"
+)
+
+// SeenItemRetention is how long an item is remembered after it was last seen in its feed.
+// Items still in the feed are never forgotten, whether or not they are dated.
+// Dated items older than this are never posted either, in case they left the feed and came back.
+const SeenItemRetention = 90 * 24 * time.Hour
+
+// feedChannel is the channel seen items are recorded against for the feed itself, rather than a subscription.
+const feedChannel = ""
+
+// itemKeys hashes what identifies an item, preferring its GUID.
+// The description (usually the link) is kept as a fallback, which is what items used to be identified by.
+func itemKeys(item *gofeed.Item, description string) []string {
+	keys := []string{}
+	if len(item.GUID) != 0 {
+		keys = append(keys, hashKey(item.GUID))
+	}
+
+	if fallback := hashKey(description); len(keys) == 0 || keys[0] != fallback {
+		keys = append(keys, fallback)
+	}
+
+	return keys
+}
+
+func hashKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(hash[:])
+}
+
+// SeenIn reports whether the item is one of the seen items.
+func (i RSSInfo) SeenIn(seen map[string]struct{}) bool {
+	for _, key := range i.Keys {
+		if _, found := seen[key]; found {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IdentifiedBy replaces the item's keys with the ID its source identifies it by.
+func (i RSSInfo) IdentifiedBy(id string) RSSInfo {
+	i.Keys = []string{hashKey(id)}
+
+	return i
+}
+
+// Stale reports whether the item is too old to be posted, because it may have been seen and since forgotten.
+func (i RSSInfo) Stale() bool {
+	date := itemDate(i.Item)
+
+	return date != nil && time.Since(*date) > SeenItemRetention
+}
+
+// SelectSeen returns which of the items have been seen by the subscription in the channel.
+// They are still in the feed, so they are remembered as having been seen again.
+func SelectSeen(
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	feedID int64,
+	channel string,
+	items []RSSInfo,
+) (map[string]struct{}, error) {
+	keys := []string{}
+	for _, item := range items {
+		keys = append(keys, item.Keys...)
+	}
+
+	rows, err := dbPool.Query(ctx, seenSelect, feedID, channel, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := map[string]struct{}{}
+
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+
+		seen[key] = struct{}{}
+	}
+
+	return seen, rows.Err()
+}
+
+// MarkSeen records the items as seen by the subscription in the channel, by their preferred key.
+func MarkSeen(ctx context.Context, dbPool *pgxpool.Pool, feedID int64, channel string, items []RSSInfo) (int64, error) {
+	tag, err := dbPool.Exec(ctx, seenInsert, feedID, channel, seenKeys(items))
+
+	return tag.RowsAffected(), err
+}
+
+// seenKeys are the preferred keys of the items, which they are recorded as seen by.
+func seenKeys(items []RSSInfo) []string {
+	keys := make([]string, 0, len(items))
+	for _, item := range items {
+		keys = append(keys, item.Keys[0])
+	}
+
+	return keys
+}
+
+// RefreshFeedSeen remembers the feeds' own seen items (which new subscriptions start from) for as long as they're
+// still in the feed, like SelectSeen does for subscriptions.
+// Watched pages are always dated when they're fetched, so otherwise subscribing to one after its unchanged content was
+// forgotten would post it again.
+func RefreshFeedSeen(ctx context.Context, dbPool *pgxpool.Pool, feedIDs []int64, items []RSSInfo) error {
+	keys := []string{}
+	for _, item := range items {
+		keys = append(keys, item.Keys...)
+	}
+
+	_, err := dbPool.Exec(ctx, seenRefresh, feedIDs, keys)
+
+	return err
+}
+
+// ForgetSeenItems removes the items that were last seen longer ago than they are kept for.
+func ForgetSeenItems(ctx context.Context, dbPool *pgxpool.Pool) (int64, error) {
+	tag, err := dbPool.Exec(ctx, seenForget, time.Now().Add(-SeenItemRetention))
+
+	return tag.RowsAffected(), err
+}
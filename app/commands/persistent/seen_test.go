@@ -0,0 +1,64 @@
+package persistent
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func TestItemKeys(t *testing.T) {
+	tests := []struct {
+		name        string
+		guid        string
+		description string
+		want        []string
+	}{
+		{
+			name:        "GUID, then the description",
+			guid:        "urn:uuid:1234",
+			description: "https://example.com/1234",
+			want:        []string{hashKey("urn:uuid:1234"), hashKey("https://example.com/1234")},
+		},
+		{
+			name:        "no GUID",
+			description: "https://example.com/1234",
+			want:        []string{hashKey("https://example.com/1234")},
+		},
+		{
+			name:        "GUID is the description",
+			guid:        "https://example.com/1234",
+			description: "https://example.com/1234",
+			want:        []string{hashKey("https://example.com/1234")},
+		},
+		{
+			name: "nothing at all",
+			want: []string{hashKey("")},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			got := itemKeys(&gofeed.Item{GUID: test.guid}, test.description)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("itemKeys(%q, %q) = %v, want %v", test.guid, test.description, got, test.want)
+			}
+		})
+	}
+}
+
+func TestHashKey(t *testing.T) {
+	if hashKey("a") != hashKey("a") {
+		t.Error("hashKey isn't stable")
+	}
+
+	if hashKey("a") == hashKey("b") {
+		t.Error("hashKey collides for different keys")
+	}
+
+	// SeenItems are stored by the hex of a SHA-256
+	if got := len(hashKey("a")); got != 64 {
+		t.Errorf("hashKey is %d characters, want 64", got)
+	}
+}
@@ -2,42 +2,64 @@ package persistent
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
 	"strings"
+	"time"
 
 	"quozlet.net/birbbot/app/commands"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 const (
-	subTableDefinition string = "CREATE TABLE IF NOT EXISTS " +
-		"Subscriptions (FeedID INTEGER PRIMARY KEY REFERENCES Feeds(ID), Channel TEXT NOT NULL)"
-	subInsert string = "INSERT INTO Subscriptions(FeedID, Channel) VALUES ($1, $2) " +
-		"ON CONFLICT (FeedID) DO UPDATE SET Channel=excluded.Channel"
-	subList string = "SELECT FeedID, Channel FROM Subscriptions"
+	// Only feeds belonging to the server can be subscribed to, and new subscriptions don't repost old items
+	subInsert string = "INSERT INTO Subscriptions(FeedID, Channel, GuildID) " +
+		"SELECT ID, $2, GuildID FROM Feeds WHERE ID = $1 AND GuildID = $3 " +
+		"ON CONFLICT (FeedID, Channel) DO NOTHING"
+	subList string = "SELECT Subscriptions.FeedID, Feeds.Title, Subscriptions.Channel, Subscriptions.Style, " +
+		"Subscriptions.MaxItems, Subscriptions.Delivery " +
+		"FROM Subscriptions " +
+		"JOIN Feeds ON Feeds.ID = Subscriptions.FeedID WHERE Subscriptions.GuildID = $1 " +
+		"ORDER BY Subscriptions.FeedID, Subscriptions.Channel"
+)
+
+const (
+	subSetStyle string = "UPDATE Subscriptions SET Style = $1 WHERE FeedID = $2 AND Channel = $3 AND GuildID = $4"
+	subSetLimit string = "UPDATE Subscriptions SET MaxItems = $1 WHERE FeedID = $2 AND Channel = $3 AND GuildID = $4"
+	// Items already waiting for a digest are posted right away when switching to immediate delivery
+	subSetDelivery string = "UPDATE Subscriptions SET Delivery = $1, DigestMinute = $2, TimeZone = $3, " +
+		"NextDigest = COALESCE($4, now()) WHERE FeedID = $5 AND Channel = $6 AND GuildID = $7"
+	subSetTemplate string = "UPDATE Subscriptions SET Template = $1 " +
+		"WHERE FeedID = $2 AND Channel = $3 AND GuildID = $4 RETURNING AllowMentions"
+	subSetMentions string = "UPDATE Subscriptions SET AllowMentions = $1 " +
+		"WHERE FeedID = $2 AND Channel = $3 AND GuildID = $4"
+	subPreviewSelect string = "SELECT Feeds.Title, Feeds.URL, Feeds.Kind, Feeds.Selector, Style, Template " +
+		"FROM Subscriptions " +
+		"JOIN Feeds ON Feeds.ID = FeedID WHERE FeedID = $1 AND Channel = $2 AND Subscriptions.GuildID = $3"
+)
+
+// The most items a subscription can have posted at once, the rest wait until the next check.
+const (
+	minItemLimit = 1
+	maxItemLimit = 25
 )
 
 // Sub is a Command to subscribe a certain RSS feed to a channel.
 type Sub struct{}
 
-// Check will assert that the Subscription table exists.
+// Check returns nil, the Subscriptions table is created by migrations.
 func (s Sub) Check(dbPool *pgxpool.Pool) error {
-	tag, err := dbPool.Exec(context.Background(), subTableDefinition)
-	if err != nil {
-		return err
-	}
-
-	log.Printf("Subscription: %s", tag)
-
 	return nil
 }
 
 // ProcessMessage will create an association between an RSS feed and channel.
 func (s Sub) ProcessMessage(
+	ctx context.Context,
 	response chan<- commands.MessageResponse,
 	m *discordgo.MessageCreate,
 	dbPool *pgxpool.Pool,
@@ -49,11 +71,33 @@ func (s Sub) ProcessMessage(
 		return commands.NewError("`sub` requires arguments")
 	}
 
+	if len(m.GuildID) == 0 {
+		return commands.NewError(guildOnlyErrorMsg)
+	}
+
 	message := splitContent[1:]
 
 	switch message[0] {
 	case "list":
-		return listSubscriptions(dbPool, response, m.ChannelID)
+		return listSubscriptions(ctx, dbPool, response, m.ChannelID, m.GuildID)
+
+	case "style":
+		return setSubscriptionStyle(ctx, dbPool, response, m, message[1:])
+
+	case "limit":
+		return setSubscriptionLimit(ctx, dbPool, response, m, message[1:])
+
+	case "digest":
+		return setSubscriptionDelivery(ctx, dbPool, response, m, message[1:])
+
+	case "template":
+		return setSubscriptionTemplate(ctx, dbPool, response, m, message[1:])
+
+	case "preview":
+		return previewSubscription(ctx, dbPool, response, m, message[1:])
+
+	case "mentions":
+		return setSubscriptionMentions(ctx, dbPool, response, m, message[1:])
 
 	default:
 		id, err := strconv.ParseInt(splitContent[1], 0, 64)
@@ -64,8 +108,12 @@ func (s Sub) ProcessMessage(
 			return commandError
 		}
 
-		channelID := string([]rune(splitContent[2])[2:20])
-		tag, err := dbPool.Exec(context.Background(), subInsert, id, channelID)
+		if len(splitContent) < 3 {
+			return commands.NewError(fmt.Sprintf("Which channel should %d be posted to? See `help sub`", id))
+		}
+
+		channelID := channelFromMention(splitContent[2])
+		tag, err := dbPool.Exec(ctx, subInsert, id, channelID, m.GuildID)
 
 		if commandError = commands.CreateCommandError(
 			"Failed to associate the feed with the channel."+
@@ -75,7 +123,22 @@ func (s Sub) ProcessMessage(
 			return commandError
 		}
 
-		log.Printf("Sub: %s (actually inserted %d, %s)", tag, id, channelID)
+		if tag.RowsAffected() == 0 {
+			return commands.NewError(fmt.Sprintf("Either %d is already posted to %s, or it isn't a feed in this server "+
+				"(check `rss list`)", id, splitContent[2]))
+		}
+
+		log.Printf("Sub: %s (actually inserted %d, %s for %s)", tag, id, channelID, m.GuildID)
+
+		seenTag, err := dbPool.Exec(ctx, seenCopy, id, channelID)
+		if commandError = commands.CreateCommandError(
+			"Subscribed, but couldn't copy what the feed has already seen, so old items may be posted",
+			err,
+		); commandError != nil {
+			return commandError
+		}
+
+		log.Printf("Sub: %s (actually copied seen items of %d to %s)", seenTag, id, channelID)
 		response <- commands.MessageResponse{
 			ChannelID: m.ChannelID,
 			Message:   fmt.Sprintf("Got it! Associated %d to %s", id, strings.Fields(m.Content)[2]),
@@ -85,52 +148,118 @@ func (s Sub) ProcessMessage(
 	}
 }
 
+// Privileged reports that allowing a subscription to ping roles requires elevated permissions.
+func (s Sub) Privileged(args []string) bool {
+	return len(args) != 0 && args[0] == "mentions"
+}
+
+// TargetChannel reports the channel the subscription is posted to, which has to exist (and be in this server).
+// Changing a subscription names its channel after the feed's ID, subscribing names it straight after the ID.
+func (s Sub) TargetChannel(args []string) (string, bool) {
+	position := 1
+
+	if len(args) != 0 {
+		switch args[0] {
+		case "list":
+			return "", false
+		case "style", "limit", "digest", "template", "preview", "mentions":
+			position = 2
+		}
+	}
+
+	if len(args) <= position {
+		return "", false
+	}
+
+	return channelFromMention(args[position]), true
+}
+
 // Help returns the help message for the RSS Command.
 func (s Sub) Help() string {
-	return "`sub <id> <channel>` subscribes the RSS feed identified by ID to the provided channel\n" +
+	return "`sub <id> <channel>` subscribes the RSS feed identified by ID to the provided channel in this server\n" +
+		"_A feed can be subscribed to as many channels as you like_\n" +
 		"_Check `rss list` for the list of RSS feeds and IDs_\n\n" +
-		"- `sub list` lists all active subscriptions\n" +
+		"- `sub list` lists every channel each feed is subscribed to in this server\n" +
+		fmt.Sprintf("- `sub style <id> <channel> <%s|%s>` changes whether new items are posted as rich embeds "+
+			"(the default) or plain text\n", EmbedStyle, TextStyle) +
+		fmt.Sprintf("- `sub limit <id> <channel> <%d-%d>` changes how many new items are posted at once "+
+			"(the rest are posted next time)\n", minItemLimit, maxItemLimit) +
+		fmt.Sprintf("- `sub digest <id> <channel> <%s|%s|%s> [HH:MM] [time zone]` posts new items together "+
+			"every hour, or every day at the given time (in UTC, or a time zone like `Europe/London`), "+
+			"instead of as soon as they're found\n", ImmediateDelivery, HourlyDelivery, DailyDelivery) +
+		"- `sub template <id> <channel> [template]` posts new items using a Go template instead of the style, e.g. " +
+		"`{{.Title}} by {{.Author}}: {{.Link}}` (leave it out to go back to the style). " +
+		"Items have `.Feed`, `.Title`, `.Link`, `.Author`, `.Categories`, `.Published`, `.Enclosure` and `.Summary`, " +
+		"podcast episodes also have `.Episode`, `.Duration`, `.Artwork` and `.EnclosureType`, " +
+		"and `truncate`, `join`, `lower` and `upper` can be used\n" +
+		"- `sub preview <id> <channel>` shows how the latest item in the feed would be posted\n" +
+		"- `sub mentions <id> <channel> <on|off>` lets roles mentioned in the template be pinged " +
+		"(only for users who can manage this server)\n" +
 		"\n_Refresh rate is once per 30 minutes per feed (but only for new content, it uses the same rules as `rss latest`)_"
 }
 
+// Permission reports the permission the Sub Command requires by default.
+// Subscribing posts into channels, so it requires being able to manage them.
+func (s Sub) Permission() int64 {
+	return discordgo.PermissionManageChannels
+}
+
 // CommandList returns a list of aliases for the RSS Command.
 func (s Sub) CommandList() []string {
 	return []string{"sub"}
 }
 
 func listSubscriptions(
+	ctx context.Context,
 	dbPool *pgxpool.Pool,
 	response chan<- commands.MessageResponse,
 	channelID string,
+	guildID string,
 ) *commands.CommandError {
 	var commandError *commands.CommandError
 
-	rows, err := dbPool.Query(context.Background(), subList)
+	rows, err := dbPool.Query(ctx, subList, guildID)
 	if commandError = commands.CreateCommandError(
 		"Couldn't read a list of subscriptions from the database!",
 		err,
 	); commandError != nil {
 		return commandError
 	}
+	defer rows.Close()
 
-	haveActiveSubs := false
+	// Rows are ordered by feed, so each feed's channels are adjacent
+	feeds := []string{}
+	channels := []string{}
+	lastID := int64(-1)
+
+	var lastTitle string
 
 	for rows.Next() {
 		var channel string
 
+		var title string
+
+		var style string
+
+		var maxItems int
+
+		var delivery string
+
 		var id int64
 		if commandError = commands.CreateCommandError(
 			"An error occurred reading a certain subscription's information. Aborting",
-			rows.Scan(&id, &channel),
+			rows.Scan(&id, &title, &channel, &style, &maxItems, &delivery),
 		); commandError != nil {
 			return commandError
 		}
 
-		haveActiveSubs = true
-		response <- commands.MessageResponse{
-			ChannelID: channelID,
-			Message:   fmt.Sprintf("%d -> <#%s>", id, channel),
+		if id != lastID && len(channels) != 0 {
+			feeds = append(feeds, fmt.Sprintf("%d: %s -> %s", lastID, lastTitle, strings.Join(channels, ", ")))
+			channels = []string{}
 		}
+
+		lastID, lastTitle = id, title
+		channels = append(channels, fmt.Sprintf("<#%s> (%s, %s, up to %d at once)", channel, style, delivery, maxItems))
 	}
 
 	if commandError = commands.CreateCommandError(
@@ -140,12 +269,401 @@ func listSubscriptions(
 		return commandError
 	}
 
-	if !haveActiveSubs {
+	if len(channels) != 0 {
+		feeds = append(feeds, fmt.Sprintf("%d: %s -> %s", lastID, lastTitle, strings.Join(channels, ", ")))
+	}
+
+	if len(feeds) == 0 {
 		response <- commands.MessageResponse{
 			ChannelID: channelID,
 			Message:   "No subscriptions are currently active",
 		}
+
+		return nil
+	}
+
+	response <- commands.MessageResponse{
+		ChannelID: channelID,
+		Message:   strings.Join(feeds, "\n"),
+	}
+
+	return nil
+}
+
+func setSubscriptionStyle(
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	response chan<- commands.MessageResponse,
+	m *discordgo.MessageCreate,
+	args []string,
+) *commands.CommandError {
+	if len(args) < 3 {
+		return commands.NewError("Which feed, channel and style? See `help sub`")
+	}
+
+	id, err := strconv.ParseInt(args[0], 0, 64)
+	if commandError := commands.CreateCommandError(
+		fmt.Sprintf("%s is not a valid ID, so I can't look up a feed using it", args[0]),
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	style := strings.ToLower(args[2])
+	if style != EmbedStyle && style != TextStyle {
+		return commands.NewError(fmt.Sprintf("`%s` isn't a style, use `%s` or `%s`", args[2], EmbedStyle, TextStyle))
+	}
+
+	channelID := channelFromMention(args[1])
+	tag, err := dbPool.Exec(ctx, subSetStyle, style, id, channelID, m.GuildID)
+
+	if commandError := commands.CreateCommandError(
+		"Failed to change the style, the old one is still in use",
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	if tag.RowsAffected() == 0 {
+		return commands.NewError(fmt.Sprintf("%d isn't posted to <#%s>, check `sub list`", id, channelID))
+	}
+
+	log.Printf("Sub: %s (actually set %s for %d, %s)", tag, style, id, channelID)
+	response <- commands.MessageResponse{
+		ChannelID: m.ChannelID,
+		Message:   fmt.Sprintf("Got it! New items from %d will be posted to <#%s> as %s", id, channelID, style),
+	}
+
+	return nil
+}
+
+func setSubscriptionLimit(
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	response chan<- commands.MessageResponse,
+	m *discordgo.MessageCreate,
+	args []string,
+) *commands.CommandError {
+	if len(args) < 3 {
+		return commands.NewError("Which feed, channel and limit? See `help sub`")
+	}
+
+	id, err := strconv.ParseInt(args[0], 0, 64)
+	if commandError := commands.CreateCommandError(
+		fmt.Sprintf("%s is not a valid ID, so I can't look up a feed using it", args[0]),
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	limit, err := strconv.Atoi(args[2])
+	if err != nil || limit < minItemLimit || limit > maxItemLimit {
+		return commands.NewError(fmt.Sprintf("The limit has to be a number from %d to %d, not %s",
+			minItemLimit, maxItemLimit, args[2]))
+	}
+
+	channelID := channelFromMention(args[1])
+	tag, err := dbPool.Exec(ctx, subSetLimit, limit, id, channelID, m.GuildID)
+
+	if commandError := commands.CreateCommandError(
+		"Failed to change the limit, the old one is still in use",
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	if tag.RowsAffected() == 0 {
+		return commands.NewError(fmt.Sprintf("%d isn't posted to <#%s>, check `sub list`", id, channelID))
+	}
+
+	log.Printf("Sub: %s (actually set a limit of %d for %d, %s)", tag, limit, id, channelID)
+	response <- commands.MessageResponse{
+		ChannelID: m.ChannelID,
+		Message:   fmt.Sprintf("Got it! Up to %d new items from %d will be posted to <#%s> at once", limit, id, channelID),
+	}
+
+	return nil
+}
+
+func setSubscriptionDelivery(
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	response chan<- commands.MessageResponse,
+	m *discordgo.MessageCreate,
+	args []string,
+) *commands.CommandError {
+	if len(args) < 3 {
+		return commands.NewError("Which feed, channel and delivery? See `help sub`")
+	}
+
+	id, err := strconv.ParseInt(args[0], 0, 64)
+	if commandError := commands.CreateCommandError(
+		fmt.Sprintf("%s is not a valid ID, so I can't look up a feed using it", args[0]),
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	delivery := strings.ToLower(args[2])
+	if delivery != ImmediateDelivery && delivery != HourlyDelivery && delivery != DailyDelivery {
+		return commands.NewError(fmt.Sprintf("`%s` isn't a delivery, use `%s`, `%s` or `%s`",
+			args[2], ImmediateDelivery, HourlyDelivery, DailyDelivery))
+	}
+
+	minute, location, commandError := parseDigestTime(delivery, args[3:])
+	if commandError != nil {
+		return commandError
+	}
+
+	channelID := channelFromMention(args[1])
+	next := NextDigest(delivery, minute, location, time.Now())
+	tag, err := dbPool.Exec(ctx, subSetDelivery, delivery, minute, location.String(), next, id, channelID, m.GuildID)
+
+	if commandError := commands.CreateCommandError(
+		"Failed to change the delivery, the old one is still in use",
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	if tag.RowsAffected() == 0 {
+		return commands.NewError(fmt.Sprintf("%d isn't posted to <#%s>, check `sub list`", id, channelID))
+	}
+
+	log.Printf("Sub: %s (actually set %s delivery for %d, %s)", tag, delivery, id, channelID)
+
+	message := fmt.Sprintf("Got it! New items from %d will be posted to <#%s> as soon as they're found", id, channelID)
+	if next != nil {
+		message = fmt.Sprintf("Got it! New items from %d will be posted to <#%s> together, starting %s",
+			id, channelID, next.In(location).Format("Mon 15:04 MST"))
+	}
+	response <- commands.MessageResponse{
+		ChannelID: m.ChannelID,
+		Message:   message,
+	}
+
+	return nil
+}
+
+// parseDigestTime parses the time of day (and time zone) that daily digests are posted at.
+// Other deliveries don't need a time, so they use midnight UTC.
+func parseDigestTime(delivery string, args []string) (int, *time.Location, *commands.CommandError) {
+	if delivery != DailyDelivery {
+		return 0, time.UTC, nil
+	}
+
+	if len(args) == 0 {
+		return 0, nil, commands.NewError("What time should the daily digest be posted? Use `HH:MM`, like `08:30`")
+	}
+
+	at, err := time.Parse("15:04", args[0])
+	if commandError := commands.CreateCommandError(
+		fmt.Sprintf("%s isn't a time, use `HH:MM`, like `08:30`", args[0]),
+		err,
+	); commandError != nil {
+		return 0, nil, commandError
+	}
+
+	location := time.UTC
+	if len(args) > 1 {
+		location, err = time.LoadLocation(args[1])
+		if commandError := commands.CreateCommandError(
+			fmt.Sprintf("%s isn't a time zone I know, try one like `Europe/London`", args[1]),
+			err,
+		); commandError != nil {
+			return 0, nil, commandError
+		}
+	}
+
+	return at.Hour()*60 + at.Minute(), location, nil
+}
+
+func setSubscriptionTemplate(
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	response chan<- commands.MessageResponse,
+	m *discordgo.MessageCreate,
+	args []string,
+) *commands.CommandError {
+	if len(args) < 2 {
+		return commands.NewError("Which feed and channel? See `help sub`")
+	}
+
+	id, err := strconv.ParseInt(args[0], 0, 64)
+	if commandError := commands.CreateCommandError(
+		fmt.Sprintf("%s is not a valid ID, so I can't look up a feed using it", args[0]),
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	// Everything after the channel is the template, spaces and new lines included
+	itemTemplate := m.Content
+	for skip := 0; skip < 4; skip++ {
+		_, itemTemplate = splitArgument(itemTemplate)
+	}
+
+	itemTemplate = strings.TrimSpace(itemTemplate)
+	if len(itemTemplate) != 0 {
+		if err := ValidateTemplate(itemTemplate); err != nil {
+			return commands.NewError(fmt.Sprintf("That template doesn't work: %s", err))
+		}
+	}
+
+	channelID := channelFromMention(args[1])
+
+	var allowMentions bool
+
+	err = dbPool.QueryRow(ctx, subSetTemplate, itemTemplate, id, channelID, m.GuildID).Scan(&allowMentions)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return commands.NewError(fmt.Sprintf("%d isn't posted to <#%s>, check `sub list`", id, channelID))
+	}
+
+	if commandError := commands.CreateCommandError(
+		"Failed to change the template, the old one is still in use",
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	log.Printf("Sub: set a template of %d characters for %d, %s", len(itemTemplate), id, channelID)
+
+	message := fmt.Sprintf("Got it! New items from %d will be posted to <#%s> using that template "+
+		"(see how with `sub preview %d %s`)", id, channelID, id, args[1])
+
+	switch {
+	case len(itemTemplate) == 0:
+		message = fmt.Sprintf("Got it! New items from %d will be posted to <#%s> in its style", id, channelID)
+	case !allowMentions && strings.Contains(itemTemplate, "<@&"):
+		message += "\n_Roles won't be pinged unless someone who can manage this server uses `sub mentions`_"
+	}
+	response <- commands.MessageResponse{
+		ChannelID: m.ChannelID,
+		Message:   message,
+	}
+
+	return nil
+}
+
+func previewSubscription(
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	response chan<- commands.MessageResponse,
+	m *discordgo.MessageCreate,
+	args []string,
+) *commands.CommandError {
+	var commandError *commands.CommandError
+
+	if len(args) < 2 {
+		return commands.NewError("Which feed and channel? See `help sub`")
+	}
+
+	id, err := strconv.ParseInt(args[0], 0, 64)
+	if commandError = commands.CreateCommandError(
+		fmt.Sprintf("%s is not a valid ID, so I can't look up a feed using it", args[0]),
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	channelID := channelFromMention(args[1])
+
+	info := &feedInfo{ID: id}
+
+	var style, itemTemplate string
+
+	err = dbPool.QueryRow(ctx, subPreviewSelect, id, channelID, m.GuildID).
+		Scan(&info.Title, &info.URL, &info.Kind, &info.Selector, &style, &itemTemplate)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return commands.NewError(fmt.Sprintf("%d isn't posted to <#%s>, check `sub list`", id, channelID))
+	}
+
+	if commandError = commands.CreateCommandError(
+		"Couldn't look up that subscription, try again later",
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	feed, err := refreshFeedInfo(ctx, info)
+	if commandError = commands.CreateCommandError(
+		"Tried to fetch the feed, but some error occurred reading it",
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	filters, err := FetchFilters(ctx, id, dbPool)
+	if commandError = commands.CreateCommandError(
+		"Couldn't look up the filters for this feed, so I can't tell which item would be posted",
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	items := Chronological(ReduceItem(feed.Items, filters))
+	if len(items) == 0 {
+		return commands.NewError("There's nothing in the feed (that the filters allow) to preview")
+	}
+
+	// Previews never ping, whatever the subscription allows
+	rendered := RenderSubscriptionItem(info.Title, items[len(items)-1], style, itemTemplate)
+	rendered.AllowedMentions = SubscriptionMentions(false)
+	rendered.ChannelID = m.ChannelID
+	response <- rendered
+
+	return nil
+}
+
+func setSubscriptionMentions(
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	response chan<- commands.MessageResponse,
+	m *discordgo.MessageCreate,
+	args []string,
+) *commands.CommandError {
+	if len(args) < 3 || (strings.ToLower(args[2]) != "on" && strings.ToLower(args[2]) != "off") {
+		return commands.NewError("Which feed and channel, and `on` or `off`? See `help sub`")
+	}
+
+	id, err := strconv.ParseInt(args[0], 0, 64)
+	if commandError := commands.CreateCommandError(
+		fmt.Sprintf("%s is not a valid ID, so I can't look up a feed using it", args[0]),
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	channelID := channelFromMention(args[1])
+	allow := strings.ToLower(args[2]) == "on"
+	tag, err := dbPool.Exec(ctx, subSetMentions, allow, id, channelID, m.GuildID)
+
+	if commandError := commands.CreateCommandError(
+		"Failed to change whether roles are pinged",
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	if tag.RowsAffected() == 0 {
+		return commands.NewError(fmt.Sprintf("%d isn't posted to <#%s>, check `sub list`", id, channelID))
+	}
+
+	log.Printf("Sub: %s (actually set mentions %s for %d, %s)", tag, args[2], id, channelID)
+
+	message := fmt.Sprintf("Got it! Roles mentioned in items from %d won't be pinged in <#%s>", id, channelID)
+	if allow {
+		message = fmt.Sprintf("Got it! Roles mentioned in items from %d will be pinged in <#%s>", id, channelID)
+	}
+	response <- commands.MessageResponse{
+		ChannelID: m.ChannelID,
+		Message:   message,
 	}
 
 	return nil
 }
+
+// channelFromMention extracts the ID from a channel mention (<#id>), or returns the argument if it isn't one.
+func channelFromMention(arg string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(arg, "<#"), ">")
+}
@@ -0,0 +1,225 @@
+package persistent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"quozlet.net/birbbot/app/commands"
+)
+
+// Templates longer than this are rejected, they only need to arrange a few fields.
+const maxTemplateLength = 1000
+
+var (
+	errTemplateTooLong  = fmt.Errorf("templates can be at most %d characters", maxTemplateLength)
+	errTemplateNested   = errors.New("templates can't define or call other templates")
+	errTemplateEmpty    = errors.New("the template doesn't render anything")
+	errTemplateRendered = fmt.Errorf("the template renders more than the %d characters Discord allows", maxMessageLength)
+)
+
+// ItemView is what a subscription's template can use to render an item.
+// It only has plain values, so a template can't reach anything but the item.
+type ItemView struct {
+	Feed       string
+	Title      string
+	Link       string
+	Author     string
+	Categories []string
+	// Published is the zero time if the feed didn't say when the item was published
+	Published time.Time
+	Enclosure string
+	Summary   string
+	// Episode, Duration, Artwork and EnclosureType are empty unless the item is a podcast episode (or has media)
+	Episode       string
+	Duration      string
+	Artwork       string
+	EnclosureType string
+}
+
+// templateFuncs are the functions available to templates, besides the builtin ones.
+var templateFuncs = template.FuncMap{
+	"truncate": func(length int, text string) string {
+		if length < 1 {
+			return ""
+		}
+
+		return truncate(text, length)
+	},
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// exampleItem is rendered to validate templates before they are saved.
+var exampleItem = ItemView{
+	Feed:          "Example Feed",
+	Title:         "Example Item",
+	Link:          "https://example.com/item",
+	Author:        "Someone",
+	Categories:    []string{"news", "examples"},
+	Published:     time.Date(2021, time.January, 1, 12, 0, 0, 0, time.UTC),
+	Enclosure:     "https://example.com/item.mp3",
+	Summary:       "What the item is about",
+	Episode:       "S1E1",
+	Duration:      "42:00",
+	Artwork:       "https://example.com/item.jpg",
+	EnclosureType: "audio/mpeg",
+}
+
+// itemView describes an item for a template.
+func itemView(feedTitle string, info RSSInfo) ItemView {
+	view := ItemView{Feed: feedTitle, Title: info.Title, Link: info.Description}
+	if info.Item == nil {
+		return view
+	}
+
+	item := info.Item
+	view.Categories = item.Categories
+	view.Summary = truncate(itemSummary(item), embedSummaryLength)
+
+	if item.Author != nil {
+		view.Author = item.Author.Name
+	}
+
+	if date := itemDate(item); date != nil {
+		view.Published = *date
+	}
+
+	if len(item.Enclosures) != 0 {
+		view.Enclosure = item.Enclosures[0].URL
+		view.EnclosureType = item.Enclosures[0].Type
+	}
+
+	if episode := itemEpisode(item); episode != nil {
+		view.Enclosure = episode.Media.URL
+		view.EnclosureType = episode.Media.Type
+		view.Episode = episode.Label()
+		view.Duration = episode.FormattedDuration()
+		view.Artwork = episode.Artwork
+	}
+
+	return view
+}
+
+// ValidateTemplate parses the template, and checks it renders an example item to a message Discord would send.
+func ValidateTemplate(text string) error {
+	if len([]rune(text)) > maxTemplateLength {
+		return errTemplateTooLong
+	}
+
+	parsed, err := parseTemplate(text)
+	if err != nil {
+		return err
+	}
+
+	_, err = executeTemplate(parsed, exampleItem)
+
+	return err
+}
+
+// RenderTemplate renders an item with a subscription's template.
+func RenderTemplate(text string, feedTitle string, info RSSInfo) (commands.MessageResponse, error) {
+	parsed, err := parseTemplate(text)
+	if err != nil {
+		return commands.MessageResponse{}, err
+	}
+
+	rendered, err := executeTemplate(parsed, itemView(feedTitle, info))
+
+	return commands.MessageResponse{Message: rendered}, err
+}
+
+// RenderSubscriptionItem renders an item with the subscription's template, or in its style if it has no template.
+// Templates were validated when they were saved, but if one fails anyway the style is used instead.
+func RenderSubscriptionItem(feedTitle string, info RSSInfo, style, itemTemplate string) commands.MessageResponse {
+	if len(itemTemplate) != 0 {
+		rendered, err := RenderTemplate(itemTemplate, feedTitle, info)
+		if err == nil {
+			return rendered
+		}
+
+		log.Printf("Falling back to the %s style, the template failed: %s", style, err)
+	}
+
+	return RenderItem(feedTitle, info, style)
+}
+
+// SubscriptionMentions is who items posted for a subscription may mention.
+// Feeds (and templates) can contain anything, so nobody is pinged unless the server allowed role mentions.
+// Even then, @everyone and @here never ping.
+func SubscriptionMentions(allowRoles bool) *discordgo.MessageAllowedMentions {
+	mentions := &discordgo.MessageAllowedMentions{Parse: []discordgo.AllowedMentionType{}}
+	if allowRoles {
+		mentions.Parse = append(mentions.Parse, discordgo.AllowedMentionTypeRoles)
+	}
+
+	return mentions
+}
+
+func parseTemplate(text string) (*template.Template, error) {
+	parsed, err := template.New("item").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	if parsed.Tree == nil {
+		return nil, errTemplateEmpty
+	}
+
+	// Templates calling each other (or themselves) can recurse, so there's only ever the one
+	if len(parsed.Templates()) > 1 || callsTemplate(parsed.Tree.Root) {
+		return nil, errTemplateNested
+	}
+
+	return parsed, nil
+}
+
+func callsTemplate(node parse.Node) bool {
+	switch node := node.(type) {
+	case *parse.TemplateNode:
+		return true
+	case *parse.ListNode:
+		if node == nil {
+			return false
+		}
+
+		for _, child := range node.Nodes {
+			if callsTemplate(child) {
+				return true
+			}
+		}
+	case *parse.IfNode:
+		return callsTemplate(node.List) || callsTemplate(node.ElseList)
+	case *parse.RangeNode:
+		return callsTemplate(node.List) || callsTemplate(node.ElseList)
+	case *parse.WithNode:
+		return callsTemplate(node.List) || callsTemplate(node.ElseList)
+	}
+
+	return false
+}
+
+func executeTemplate(parsed *template.Template, view ItemView) (string, error) {
+	rendered := &bytes.Buffer{}
+	if err := parsed.Execute(rendered, view); err != nil {
+		return "", err
+	}
+
+	message := strings.TrimSpace(rendered.String())
+
+	switch {
+	case len(message) == 0:
+		return "", errTemplateEmpty
+	case len([]rune(message)) > maxMessageLength:
+		return "", errTemplateRendered
+	}
+
+	return message, nil
+}
@@ -0,0 +1,123 @@
+package persistent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"quozlet.net/birbbot/app/commands"
+)
+
+const (
+	subDelete string = "DELETE FROM Subscriptions WHERE FeedID = $1 AND Channel = $2 AND GuildID = $3"
+	subFind   string = "SELECT Feeds.Title FROM Subscriptions JOIN Feeds ON Feeds.ID = Subscriptions.FeedID " +
+		"WHERE Subscriptions.FeedID = $1 AND Subscriptions.Channel = $2 AND Subscriptions.GuildID = $3"
+)
+
+// Unsub is a Command to stop posting an RSS feed to a channel.
+type Unsub struct{}
+
+// Check returns nil, the Subscriptions table is created by migrations.
+func (u Unsub) Check(dbPool *pgxpool.Pool) error {
+	return nil
+}
+
+// ProcessMessage will remove the association between an RSS feed and a channel (this one, if none is provided).
+func (u Unsub) ProcessMessage(
+	ctx context.Context,
+	response chan<- commands.MessageResponse,
+	m *discordgo.MessageCreate,
+	dbPool *pgxpool.Pool,
+) *commands.CommandError {
+	if len(m.GuildID) == 0 {
+		return commands.NewError(guildOnlyErrorMsg)
+	}
+
+	splitContent := strings.Fields(m.Content)
+	if len(splitContent) < 2 {
+		return commands.NewError("Which feed? Provide an ID from `sub list`")
+	}
+
+	id, err := strconv.ParseInt(splitContent[1], 0, 64)
+	if commandError := commands.CreateCommandError(
+		fmt.Sprintf("%s is not a valid ID, so I can't look up a feed using it", splitContent[1]),
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	channelID := m.ChannelID
+	if len(splitContent) > 2 {
+		channelID = channelFromMention(splitContent[2])
+	}
+
+	var title string
+	if commandError := commands.CreateCommandError(
+		fmt.Sprintf("%d isn't posted to <#%s>, check `sub list`", id, channelID),
+		dbPool.QueryRow(ctx, subFind, id, channelID, m.GuildID).Scan(&title),
+	); commandError != nil {
+		return commandError
+	}
+
+	if !commands.Confirm(ctx, response, m.ChannelID, m.Author.ID,
+		fmt.Sprintf("Stop posting **%s** to <#%s>?", title, channelID),
+	) {
+		response <- commands.MessageResponse{
+			ChannelID: m.ChannelID,
+			Message:   removeCancelMsg,
+		}
+
+		return nil
+	}
+
+	tag, err := dbPool.Exec(ctx, subDelete, id, channelID, m.GuildID)
+	if commandError := commands.CreateCommandError(
+		"Failed to remove that subscription, it's still there",
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	if tag.RowsAffected() == 0 {
+		return commands.NewError(fmt.Sprintf("%d isn't posted to <#%s>, check `sub list`", id, channelID))
+	}
+
+	log.Printf("Unsub: %s (actually removed %d, %s for %s)", tag, id, channelID, m.GuildID)
+	response <- commands.MessageResponse{
+		ChannelID: m.ChannelID,
+		Message:   fmt.Sprintf("Got it! **%s** will no longer be posted to <#%s>", title, channelID),
+	}
+
+	return nil
+}
+
+// TargetChannel reports the channel to stop posting to, which needn't exist, since it may have been deleted.
+func (u Unsub) TargetChannel(args []string) (string, bool) {
+	if len(args) < 2 {
+		return "", false
+	}
+
+	return channelFromMention(args[1]), false
+}
+
+// Permission reports the permission the Unsub Command requires by default.
+// Unsubscribing changes what is posted into channels, so it requires being able to manage them.
+func (u Unsub) Permission() int64 {
+	return discordgo.PermissionManageChannels
+}
+
+// CommandList returns a list of aliases for the Unsub Command.
+func (u Unsub) CommandList() []string {
+	return []string{"unsub"}
+}
+
+// Help returns the help message for the Unsub Command.
+func (u Unsub) Help() string {
+	return "`unsub <id> [channel]` stops posting the RSS feed identified by ID to the provided channel " +
+		"(or this one, if none is provided), after you confirm\n" +
+		"_Check `sub list` for the list of subscriptions. The feed itself is kept, see `rss remove` to remove it_"
+}
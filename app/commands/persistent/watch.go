@@ -0,0 +1,195 @@
+package persistent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/mmcdole/gofeed"
+	"quozlet.net/birbbot/app/commands"
+)
+
+// Kinds of feeds, i.e. how a feed's URL is read into items.
+const (
+	// RSSFeed is an RSS, Atom or JSON feed.
+	RSSFeed = "rss"
+	// PageWatcher is a web page, with a new item whenever what a CSS selector matches changes.
+	PageWatcher = "page"
+	// ReleasesWatcher is a GitHub repository, with an item for each release.
+	ReleasesWatcher = "releases"
+)
+
+const watchNewFeed string = "INSERT INTO Feeds(GuildID, Title, URL, Kind, Selector) VALUES ($1, $2, $3, $4, $5) " +
+	"ON CONFLICT (GuildID, Kind, URL, Selector) DO NOTHING RETURNING ID"
+
+const (
+	watchAddedMsg   = "Watching **%s** (ID %d), post it to a channel with `sub %d <channel>`"
+	watchExistsMsg  = "**%s** is already being watched, check `rss list` for its ID"
+	watchUsageError = "Watch what? See `help watch`"
+)
+
+// Watch is a command to add feeds that aren't RSS feeds, but are checked (and subscribed to) like them.
+type Watch struct{}
+
+// Check returns nil, the Feeds table is created by migrations.
+func (w Watch) Check(dbPool *pgxpool.Pool) error {
+	return nil
+}
+
+// ProcessMessage adds a watched page or GitHub repository to the server.
+func (w Watch) ProcessMessage(
+	ctx context.Context,
+	response chan<- commands.MessageResponse,
+	m *discordgo.MessageCreate,
+	dbPool *pgxpool.Pool,
+) *commands.CommandError {
+	if len(m.GuildID) == 0 {
+		return commands.NewError(guildOnlyErrorMsg)
+	}
+
+	// Selectors are case sensitive, so the arguments are split from the message as it was sent
+	_, args := splitArgument(m.Content)
+	kind, args := splitArgument(args)
+
+	switch strings.ToLower(kind) {
+	case PageWatcher:
+		return watchPage(ctx, response, m, args, dbPool)
+
+	case ReleasesWatcher:
+		return watchReleases(ctx, response, m, args, dbPool)
+
+	default:
+		return commands.NewError(watchUsageError)
+	}
+}
+
+func watchPage(
+	ctx context.Context,
+	response chan<- commands.MessageResponse,
+	m *discordgo.MessageCreate,
+	args string,
+	dbPool *pgxpool.Pool,
+) *commands.CommandError {
+	pageURL, selector := splitArgument(args)
+	if len(pageURL) == 0 || len(selector) == 0 {
+		return commands.NewError("Which page, and what on it? I need a URL and a CSS selector, like `main h1`")
+	}
+
+	parsed, err := url.Parse(pageURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || len(parsed.Host) == 0 {
+		return commands.NewError(fmt.Sprintf("%s doesn't look like the link to a web page", pageURL))
+	}
+
+	return addWatcher(ctx, response, m, dbPool, PageWatcher, parsed.String(), selector)
+}
+
+func watchReleases(
+	ctx context.Context,
+	response chan<- commands.MessageResponse,
+	m *discordgo.MessageCreate,
+	args string,
+	dbPool *pgxpool.Pool,
+) *commands.CommandError {
+	repository, _ := splitArgument(args)
+
+	name, err := githubRepositoryName(repository)
+	if commandError := commands.CreateCommandError(
+		"Which repository? I need its name like `owner/repo`, or a link to it",
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	return addWatcher(ctx, response, m, dbPool, ReleasesWatcher, fmt.Sprintf(githubReleasesURL, name), name)
+}
+
+// addWatcher checks the watcher can be read, then adds it like any other feed.
+// For releases, the selector is the repository's name (which the URL is built from).
+func addWatcher(
+	ctx context.Context,
+	response chan<- commands.MessageResponse,
+	m *discordgo.MessageCreate,
+	dbPool *pgxpool.Pool,
+	kind, watchURL, selector string,
+) *commands.CommandError {
+	var commandError *commands.CommandError
+
+	feed, err := RefreshDocument(ctx, watchURL, ParserFor(kind, watchURL, selector))
+	if err != nil {
+		return commands.CreateCommandError(
+			fmt.Sprintf("Tried to read <%s>, but it didn't work out: %s", watchURL, err),
+			err,
+		)
+	}
+
+	existing := ReduceItem(feed.Items, nil)
+
+	var id int64
+
+	err = dbPool.QueryRow(ctx, watchNewFeed, m.GuildID, feed.Title, watchURL, kind, selector).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return commands.NewError(fmt.Sprintf(watchExistsMsg, feed.Title))
+	}
+
+	if commandError = commands.CreateCommandError(
+		"Couldn't save that into the database, try again later",
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	// Only what changes (or is released) after it is added should be posted
+	if commandError = markPosted(dbPool, existing, id); commandError != nil {
+		return commandError
+	}
+
+	log.Printf("Watch: inserted row %d for %s watching %s (%s %s)", id, m.GuildID, feed.Title, kind, selector)
+	response <- commands.MessageResponse{
+		ChannelID: m.ChannelID,
+		Message:   fmt.Sprintf(watchAddedMsg, feed.Title, id, id),
+	}
+
+	return nil
+}
+
+// ParserFor is how a feed of the given kind is read.
+func ParserFor(kind, feedURL, selector string) DocumentParser {
+	switch kind {
+	case PageWatcher:
+		return ParsePage(feedURL, selector)
+	case ReleasesWatcher:
+		return ParseReleases(selector)
+	default:
+		return ParseFeed
+	}
+}
+
+// refreshFeedInfo fetches a feed of any kind, see RefreshDocument.
+func refreshFeedInfo(ctx context.Context, info *feedInfo) (*gofeed.Feed, error) {
+	return RefreshDocument(ctx, info.URL, ParserFor(info.Kind, info.URL, info.Selector))
+}
+
+// Permission reports the permission the Watch Command requires by default.
+// Watchers are posted into channels like feeds, so adding them requires being able to manage channels.
+func (w Watch) Permission() int64 {
+	return discordgo.PermissionManageChannels
+}
+
+// CommandList returns a list of aliases for the Watch Command.
+func (w Watch) CommandList() []string {
+	return []string{"watch"}
+}
+
+// Help explains the usage of the Watch Command.
+func (w Watch) Help() string {
+	return "Watches things that aren't feeds, which can then be subscribed to (and filtered) like feeds\n" +
+		"- `watch page <url> <CSS selector>` posts whenever the text the selector matches on the page changes\n" +
+		"- `watch releases <owner/repo>` posts every new release of a GitHub repository " +
+		"(prereleases are titled `(" + PrereleaseCategory + ")`, so a title filter can leave them out)"
+}
@@ -1,6 +1,7 @@
 package weather
 
 import (
+	"context"
 	"log"
 	"strings"
 
@@ -19,6 +20,7 @@ func (f Forecast) Check(dbPool *pgxpool.Pool) error {
 
 // ProcessMessage processes a given message and fetches the weather for the location specified for the day specified.
 func (f Forecast) ProcessMessage(
+	ctx context.Context,
 	response chan<- commands.MessageResponse,
 	m *discordgo.MessageCreate,
 	dbPool *pgxpool.Pool,
@@ -28,7 +30,7 @@ func (f Forecast) ProcessMessage(
 	message := strings.Fields(m.Content)[1:]
 	// Start of extended forcast (lines 7-17)
 	start, end := 7, 17
-	url, err := createWeatherURL(message, m.Author.ID, dbPool)
+	url, err := createWeatherURL(ctx, message, m.Author.ID, dbPool)
 
 	if commandError = commands.CreateCommandError(
 		"Tried to create a plan to fetch the weather, but it failed",
@@ -44,11 +46,11 @@ func (f Forecast) ProcessMessage(
 		case "tomorrow":
 			start += weatherWidth
 			end += weatherWidth
-			url, err = createWeatherURL(message[1:], m.Author.ID, dbPool)
+			url, err = createWeatherURL(ctx, message[1:], m.Author.ID, dbPool)
 		case "last":
 			start += 2 * weatherWidth
 			end += 2 * weatherWidth
-			url, err = createWeatherURL(message[1:], m.Author.ID, dbPool)
+			url, err = createWeatherURL(ctx, message[1:], m.Author.ID, dbPool)
 		}
 	}
 
@@ -60,7 +62,7 @@ func (f Forecast) ProcessMessage(
 		return commandError
 	}
 
-	forecast, err := detailedWeather(url, start, end)
+	forecast, err := detailedWeather(ctx, url, start, end)
 
 	if commandError = commands.CreateCommandError(
 		"Couldn't get the forecast for that location for some reason",
@@ -1,16 +1,12 @@
 package weather
 
 import (
-	"context"
-	"log"
 	"net/url"
 
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 const (
-	weatherTableDefinition string = "CREATE TABLE IF NOT EXISTS Weather (DiscordUserID TEXT PRIMARY KEY, " +
-		"Location TEXT NOT NULL)"
 	weatherNewDefault string = "INSERT INTO Weather (DiscordUserID, Location) VALUES ($1, $2) " +
 		"ON CONFLICT(DiscordUserID) DO UPDATE SET Location=excluded.Location"
 	weatherSelect string = "SELECT Location FROM Weather WHERE DiscordUserID = $1"
@@ -63,16 +59,8 @@ const weatherURL = "https://wttr.in"
 const weatherWidth = 10
 
 func canFetchWeather(dbPool *pgxpool.Pool) error {
-	if _, err := url.Parse(weatherURL); err != nil {
-		return err
-	}
+	// The Weather table is created by migrations
+	_, err := url.Parse(weatherURL)
 
-	tag, err := dbPool.Exec(context.Background(), weatherTableDefinition)
-	if err != nil {
-		return err
-	}
-
-	log.Printf("Weather/Forecast: %s", tag)
-
-	return nil
+	return err
 }
@@ -30,6 +30,7 @@ func (w Weather) Check(dbPool *pgxpool.Pool) error {
 
 // ProcessMessage processes a given message and fetches the weather for the location specified in the format specified.
 func (w Weather) ProcessMessage(
+	ctx context.Context,
 	response chan<- commands.MessageResponse,
 	m *discordgo.MessageCreate,
 	dbPool *pgxpool.Pool,
@@ -46,20 +47,20 @@ func (w Weather) ProcessMessage(
 
 			switch strings.ToLower(splitCmd[1]) {
 			case "simple":
-				return handleSimple(response, m.ChannelID, splitCmd[2:], m.Author.ID, dbPool)
+				return handleSimple(ctx, response, m.ChannelID, splitCmd[2:], m.Author.ID, dbPool)
 
 			case "classic":
-				return handleClassic(context.Background(), response, m.ChannelID, splitCmd[2:], m.Author.ID, dbPool)
+				return handleClassic(ctx, response, m.ChannelID, splitCmd[2:], m.Author.ID, dbPool)
 
 			case "set":
-				return setWeatherPreference(context.Background(), response, m.ChannelID, splitCmd[2:], m.Author.ID, dbPool)
+				return setWeatherPreference(ctx, response, m.ChannelID, splitCmd[2:], m.Author.ID, dbPool)
 
 			case "clear":
-				return clearWeatherPreference(response, m.ChannelID, m.Author.ID, dbPool)
+				return clearWeatherPreference(ctx, response, m.ChannelID, m.Author.ID, dbPool)
 			}
 		}
 
-		url, err := createWeatherURL(splitCmd[1:], m.Author.ID, dbPool)
+		url, err := createWeatherURL(ctx, splitCmd[1:], m.Author.ID, dbPool)
 		if commandError = commands.CreateCommandError(
 			"Tried to create plan to get weather, but it failed. "+
 				"If this occurred when you thought a location was set, it probably isn't",
@@ -68,7 +69,7 @@ func (w Weather) ProcessMessage(
 			return commandError
 		}
 		// Current forecast (lines 1-7).
-		forecast, weatherErr := detailedWeather(url, 1, 7)
+		forecast, weatherErr := detailedWeather(ctx, url, 1, 7)
 		if commandError = commands.CreateCommandError(
 			"Unable to get the weather!"+
 				" Sorry",
@@ -113,7 +114,7 @@ func handleClassic(
 ) *commands.CommandError {
 	var commandError *commands.CommandError
 
-	url, err := createWeatherURL(location, discordUserID, dbPool)
+	url, err := createWeatherURL(ctx, location, discordUserID, dbPool)
 
 	if commandError = commands.CreateCommandError(
 		"Tried to create plan to get weather, but it failed.",
@@ -175,6 +176,7 @@ func formatClassicMessage(body *weatherReport, precip string) string {
 }
 
 func handleSimple(
+	ctx context.Context,
 	response chan<- commands.MessageResponse,
 	channelID string,
 	location []string,
@@ -183,7 +185,7 @@ func handleSimple(
 ) *commands.CommandError {
 	var commandError *commands.CommandError
 
-	url, err := createWeatherURL(location, discordUserID, dbPool)
+	url, err := createWeatherURL(ctx, location, discordUserID, dbPool)
 
 	if commandError = commands.CreateCommandError(
 		"Tried to create plan to get weather, but it failed.",
@@ -195,7 +197,7 @@ func handleSimple(
 	q := url.Query()
 	q.Set("format", "4")
 	url.RawQuery = q.Encode()
-	body, err := weatherResponse(url)
+	body, err := weatherResponse(ctx, url)
 
 	if commandError = commands.CreateCommandError(
 		"Tried to get the weather forecast, but couldn't fetch it",
@@ -230,7 +232,7 @@ func setWeatherPreference(
 ) *commands.CommandError {
 	var commandError *commands.CommandError
 
-	url, urlErr := createWeatherURL(location, discordUserID, dbPool)
+	url, urlErr := createWeatherURL(ctx, location, discordUserID, dbPool)
 
 	if commandError = commands.CreateCommandError(
 		"Tried to create plan to get weather, but it failed.",
@@ -239,7 +241,7 @@ func setWeatherPreference(
 		return commandError
 	}
 
-	tag, err := dbPool.Exec(context.Background(), weatherNewDefault, discordUserID, url.String())
+	tag, err := dbPool.Exec(ctx, weatherNewDefault, discordUserID, url.String())
 
 	if commandError = commands.CreateCommandError(
 		"Sorry, I couldn't save your location."+
@@ -276,6 +278,7 @@ func setWeatherPreference(
 }
 
 func clearWeatherPreference(
+	ctx context.Context,
 	response chan<- commands.MessageResponse,
 	channelID string,
 	discordUserID string,
@@ -283,7 +286,7 @@ func clearWeatherPreference(
 ) *commands.CommandError {
 	var commandError *commands.CommandError
 
-	tag, err := dbPool.Exec(context.Background(), weatherDrop, discordUserID)
+	tag, err := dbPool.Exec(ctx, weatherDrop, discordUserID)
 
 	if commandError = commands.CreateCommandError(
 		"Couldn't clear the database."+
@@ -305,10 +308,10 @@ func clearWeatherPreference(
 	return nil
 }
 
-func createWeatherURL(location []string, authorID string, dbPool *pgxpool.Pool) (*url.URL, error) {
+func createWeatherURL(ctx context.Context, location []string, authorID string, dbPool *pgxpool.Pool) (*url.URL, error) {
 	if len(location) == 0 {
 		var savedLocation string
-		if err := dbPool.QueryRow(context.Background(), weatherSelect, authorID).Scan(&savedLocation); err != nil {
+		if err := dbPool.QueryRow(ctx, weatherSelect, authorID).Scan(&savedLocation); err != nil {
 			return nil, err
 		} else if len(savedLocation) == 0 {
 			return nil, errNoLocation
@@ -336,9 +339,9 @@ func createWeatherURL(location []string, authorID string, dbPool *pgxpool.Pool)
 	return url, nil
 }
 
-func weatherResponse(url *url.URL) (string, error) {
+func weatherResponse(ctx context.Context, url *url.URL) (string, error) {
 	client := &http.Client{}
-	request, err := http.NewRequest("GET", url.String(), nil)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url.String(), nil)
 	if err != nil {
 		return "", err
 	}
@@ -361,8 +364,8 @@ func weatherResponse(url *url.URL) (string, error) {
 	return string(body), nil
 }
 
-func detailedWeather(url *url.URL, startLine int, endLine int) (string, error) {
-	body, err := weatherResponse(url)
+func detailedWeather(ctx context.Context, url *url.URL, startLine int, endLine int) (string, error) {
+	body, err := weatherResponse(ctx, url)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,172 @@
+package recurring
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"quozlet.net/birbbot/app/commands"
+	"quozlet.net/birbbot/app/commands/persistent"
+	handler "quozlet.net/birbbot/util"
+)
+
+const (
+	digestDueList string = "SELECT Subscriptions.ID, Feeds.Title, Channel, Subscriptions.GuildID, Style, Delivery, " +
+		"DigestMinute, TimeZone FROM Subscriptions JOIN Feeds ON Feeds.ID = FeedID WHERE NextDigest <= now()"
+	digestItemList string = "SELECT ID, Title, Link FROM DigestItems WHERE SubscriptionID = $1 ORDER BY ID"
+	digestClear    string = "DELETE FROM DigestItems WHERE SubscriptionID = $1 AND ID <= $2"
+	digestAdvance  string = "UPDATE Subscriptions SET NextDigest = $1 WHERE ID = $2"
+)
+
+// DigestPost routinely posts the items buffered for subscriptions that deliver digests.
+type DigestPost struct{}
+
+// digest is a subscription whose digest is due.
+type digest struct {
+	subscriptionID int64
+	title          string
+	channel        string
+	guildID        string
+	style          string
+	delivery       string
+	minute         int
+	timeZone       string
+}
+
+// Check posts a message for every due digest with items in it, and schedules the next one.
+func (d DigestPost) Check(ctx context.Context, dbPool *pgxpool.Pool) ([]commands.MessageResponse, error) {
+	due, err := selectDueDigests(ctx, dbPool)
+	if err != nil {
+		return nil, err
+	}
+
+	pendingMessages := []commands.MessageResponse{}
+	failures := &rowFailures{}
+
+	for _, digest := range due {
+		failures.record(postDigest(ctx, dbPool, digest, &pendingMessages))
+	}
+
+	return pendingMessages, failures.err()
+}
+
+// Schedule checks for due digests every minute, so daily digests are posted at the minute they were asked for.
+func (d DigestPost) Schedule() Schedule {
+	return MustCron("* * * * *")
+}
+
+func selectDueDigests(ctx context.Context, dbPool *pgxpool.Pool) ([]digest, error) {
+	rows, err := dbPool.Query(ctx, digestDueList)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	due := []digest{}
+
+	for rows.Next() {
+		var d digest
+		if err := rows.Scan(
+			&d.subscriptionID,
+			&d.title,
+			&d.channel,
+			&d.guildID,
+			&d.style,
+			&d.delivery,
+			&d.minute,
+			&d.timeZone,
+		); err != nil {
+			return nil, err
+		}
+
+		due = append(due, d)
+	}
+
+	return due, rows.Err()
+}
+
+// postDigest renders the buffered items (if there are any), and schedules the next digest.
+// The items are only forgotten once the digest has been posted, otherwise they're kept for the next one.
+func postDigest(
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	due digest,
+	pendingMessages *[]commands.MessageResponse,
+) error {
+	items, lastID, err := selectDigestItems(ctx, dbPool, due.subscriptionID)
+	if err != nil {
+		return err
+	}
+
+	location, err := time.LoadLocation(due.timeZone)
+	if err != nil {
+		location = time.UTC
+	}
+
+	writeCtx, cancel := persistent.PostedItemsContext()
+	defer cancel()
+
+	// Even if the digest can't be posted, it isn't retried until the next one is due
+	next := persistent.NextDigest(due.delivery, due.minute, location, time.Now())
+	if _, err := dbPool.Exec(writeCtx, digestAdvance, next, due.subscriptionID); err != nil {
+		return err
+	}
+
+	log.Printf("DigestPost: %d items for subscription %d", len(items), due.subscriptionID)
+
+	if len(items) == 0 {
+		return nil
+	}
+
+	rendered := persistent.RenderDigest(due.title, items, due.style)
+	rendered.AllowedMentions = persistent.SubscriptionMentions(false)
+	rendered.ChannelID = due.channel
+	rendered.GuildID = due.guildID
+	rendered.Delivered = func(sent bool) {
+		if !sent {
+			log.Printf("DigestPost: couldn't post subscription %d, keeping its items for the next digest",
+				due.subscriptionID)
+
+			return
+		}
+
+		writeCtx, cancel := persistent.PostedItemsContext()
+		defer cancel()
+
+		_, err := dbPool.Exec(writeCtx, digestClear, due.subscriptionID, lastID)
+		handler.LogErrorMsg(fmt.Sprintf("Failed to clear the digest of subscription %d", due.subscriptionID), err)
+	}
+
+	*pendingMessages = append(*pendingMessages, rendered)
+
+	return nil
+}
+
+func selectDigestItems(ctx context.Context, dbPool *pgxpool.Pool, subscriptionID int64) (
+	[]persistent.DigestItem,
+	int64,
+	error,
+) {
+	rows, err := dbPool.Query(ctx, digestItemList, subscriptionID)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	items := []persistent.DigestItem{}
+
+	var lastID int64
+
+	for rows.Next() {
+		var item persistent.DigestItem
+		if err := rows.Scan(&lastID, &item.Title, &item.Link); err != nil {
+			return nil, 0, err
+		}
+
+		items = append(items, item)
+	}
+
+	return items, lastID, rows.Err()
+}
@@ -1,5 +1,7 @@
 package recurring
 
+import "time"
+
 // Frequency at which the command should refresh.
 type Frequency int
 
@@ -21,3 +23,32 @@ const (
 	// QuarterToHourly refreshes every quarter to an hour (45 minutes).
 	QuarterToHourly
 )
+
+// Interval between each refresh.
+func (f Frequency) Interval() time.Duration {
+	switch f {
+	case Daily:
+		return 24 * time.Hour
+	case Hourly:
+		return time.Hour
+	case Minutely:
+		return time.Minute
+	case FiveMinutely:
+		return 5 * time.Minute
+	case TenMinutely:
+		return 10 * time.Minute
+	case QuarterHourly:
+		return 15 * time.Minute
+	case HalfHourly:
+		return 30 * time.Minute
+	case QuarterToHourly:
+		return 45 * time.Minute
+	default:
+		return 24 * time.Hour
+	}
+}
+
+// Next makes every Frequency a Schedule, refreshing once per interval.
+func (f Frequency) Next(t time.Time) time.Time {
+	return Every(f.Interval()).Next(t)
+}
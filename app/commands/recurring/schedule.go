@@ -0,0 +1,41 @@
+package recurring
+
+import (
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Schedule determines when a RecurringCommand should run.
+type Schedule interface {
+	// Next returns the next time to run after the given time, or the zero time if it should never run again
+	Next(time.Time) time.Time
+}
+
+type interval time.Duration
+
+func (i interval) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(i))
+}
+
+// Every returns a Schedule that runs once per interval, timed from the end of the previous run.
+func Every(duration time.Duration) Schedule {
+	return interval(duration)
+}
+
+// Cron returns a Schedule for a standard cron expression (minute, hour, day of month, month, day of week)
+// or descriptor (such as "@daily"). Times are in the local time zone, unless prefixed with e.g. "CRON_TZ=UTC ".
+func Cron(expression string) (Schedule, error) {
+	return cron.ParseStandard(expression)
+}
+
+// MustCron is like Cron, but panics if the expression can't be parsed.
+// It is intended for schedules that are fixed when the RecurringCommand is written.
+func MustCron(expression string) Schedule {
+	schedule, err := Cron(expression)
+	if err != nil {
+		panic(err)
+	}
+
+	return schedule
+}
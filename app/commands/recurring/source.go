@@ -0,0 +1,109 @@
+package recurring
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/mmcdole/gofeed"
+	"quozlet.net/birbbot/app/commands"
+	"quozlet.net/birbbot/app/commands/persistent"
+)
+
+// Source is where a kind of feed gets its items from, e.g. an RSS feed or a watched page.
+// Every kind of feed is subscribed to, filtered and deduplicated the same way, only fetching items differs.
+type Source interface {
+	// Fetch returns the items of the feeds watching the URL (with the selector), which are fetched together.
+	// If there's nothing to check (e.g. it hasn't changed) one of persistent's feed errors is returned instead.
+	Fetch(ctx context.Context, dbPool *pgxpool.Pool, feedIDs []int64, url, selector string) (*persistent.Fetched, error)
+	// ItemID identifies an item, so it is only posted once.
+	// An empty ID identifies it by its GUID, or failing that its link (or description).
+	ItemID(item *gofeed.Item) string
+	// Render builds the message posting an item, in the subscription's style (or with its template).
+	Render(feedTitle string, item persistent.RSSInfo, style, itemTemplate string) commands.MessageResponse
+}
+
+// sources are where each kind of feed gets its items from.
+var sources = map[string]Source{
+	persistent.RSSFeed:         rssSource{},
+	persistent.PageWatcher:     pageSource{},
+	persistent.ReleasesWatcher: releasesSource{},
+}
+
+// sourceFor returns the source for a kind of feed.
+func sourceFor(kind string) (Source, error) {
+	source, found := sources[kind]
+	if !found {
+		return nil, fmt.Errorf("feeds of kind %s can't be checked", kind)
+	}
+
+	return source, nil
+}
+
+// itemRenderer renders items the same way whatever their source, since every source's items are feed items.
+type itemRenderer struct{}
+
+func (r itemRenderer) Render(
+	feedTitle string,
+	item persistent.RSSInfo,
+	style, itemTemplate string,
+) commands.MessageResponse {
+	return persistent.RenderSubscriptionItem(feedTitle, item, style, itemTemplate)
+}
+
+// rssSource fetches RSS, Atom and JSON feeds.
+type rssSource struct {
+	itemRenderer
+}
+
+func (s rssSource) Fetch(
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	feedIDs []int64,
+	url, _ string,
+) (*persistent.Fetched, error) {
+	return persistent.FetchFeed(ctx, dbPool, feedIDs, url)
+}
+
+// ItemID leaves feed items to be identified by their GUID, falling back to how items were identified before GUIDs.
+func (s rssSource) ItemID(item *gofeed.Item) string {
+	return ""
+}
+
+// pageSource watches what a CSS selector matches on a web page.
+type pageSource struct {
+	itemRenderer
+}
+
+func (s pageSource) Fetch(
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	feedIDs []int64,
+	url, selector string,
+) (*persistent.Fetched, error) {
+	return persistent.FetchDocument(ctx, dbPool, feedIDs, url, persistent.ParsePage(url, selector))
+}
+
+// ItemID is the hash of the text the selector matched, the page's link is the same whatever changed.
+func (s pageSource) ItemID(item *gofeed.Item) string {
+	return item.GUID
+}
+
+// releasesSource watches the releases of a GitHub repository, which is the selector.
+type releasesSource struct {
+	itemRenderer
+}
+
+func (s releasesSource) Fetch(
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	feedIDs []int64,
+	url, repository string,
+) (*persistent.Fetched, error) {
+	return persistent.FetchDocument(ctx, dbPool, feedIDs, url, persistent.ParseReleases(repository))
+}
+
+// ItemID is the release's tag.
+func (s releasesSource) ItemID(item *gofeed.Item) string {
+	return item.GUID
+}
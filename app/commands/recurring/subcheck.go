@@ -5,223 +5,580 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"net/url"
+	"sync"
+	"time"
 
 	handler "quozlet.net/birbbot/util"
 
-	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/mmcdole/gofeed"
+	"quozlet.net/birbbot/app/commands"
 	"quozlet.net/birbbot/app/commands/persistent"
 )
 
 // SubCheck routines checks for new items in the feed.
 type SubCheck struct{}
 
-const subList string = "SELECT FeedID, Channel FROM Subscriptions"
+const (
+	subList string = "SELECT Subscriptions.ID, FeedID, Feeds.Kind, Feeds.URL, Feeds.Selector, Feeds.Title, " +
+		"Channel, Subscriptions.GuildID, Style, MaxItems, Delivery, Template, AllowMentions " +
+		"FROM Subscriptions JOIN Feeds ON Feeds.ID = FeedID ORDER BY FeedID"
+	subDiedList string = "SELECT Channel, Subscriptions.GuildID, FeedID, Feeds.Title FROM Subscriptions " +
+		"JOIN Feeds ON Feeds.ID = FeedID WHERE FeedID = ANY($1)"
+)
+
+const moreItemsMsg = "+%d more new items from **%s**, I'll post them next time"
+
+const feedDiedMsg = "**%s** failed to update %d times in a row, so I've stopped checking it. " +
+	"Once it's fixed, use `rss revive %d` to start checking it again"
+
+// How many feeds are fetched at the same time.
+const feedWorkers = 8
 
 var errNoFeedItems = errors.New("Fetched ok, but no items in feed")
 
-// Check will look for updates in subscribed feeds.
-func (s SubCheck) Check(dbPool *pgxpool.Pool) map[string][]string {
-	rows, err := dbPool.Query(context.Background(), subList)
-	if err != nil {
-		log.Println(err)
+// Check will look for updates in subscribed feeds (of every kind, see Source).
+// Every subscription is loaded first, then each feed URL is fetched once by a bounded pool of workers.
+// Subscriptions that fail to update don't prevent the others from being posted, but are reported in the error.
+// Items are only posted if the channel belongs to the same server as the subscription.
+func (s SubCheck) Check(ctx context.Context, dbPool *pgxpool.Pool) ([]commands.MessageResponse, error) {
+	started := time.Now()
 
-		return nil
+	groups, err := loadSubscriptions(ctx, dbPool)
+	if err != nil {
+		return nil, err
 	}
 
-	pendingMessages := make(map[string][]string)
-	// For each ID, map channel to pending messages, return chunk
-	for rows.Next() {
-		if err := processSubCheckRow(dbPool, &rows, &pendingMessages); err != nil {
-			continue
+	results := make([]feedResult, len(groups))
+	forEachFeed(groups, func(i int, group *feedGroup) {
+		results[i] = safelyCheckFeed(ctx, dbPool, group)
+	})
+
+	pendingMessages := []commands.MessageResponse{}
+	failures := &rowFailures{}
+	fetched, newItems := 0, 0
+
+	for _, result := range results {
+		pendingMessages = append(pendingMessages, result.messages...)
+		failures.merge(result.failures)
+		newItems += result.newItems
+
+		if result.fetched {
+			fetched++
 		}
 	}
 
-	if err := rows.Err(); err != nil {
-		log.Println(err)
+	log.Printf("SubCheck: fetched %d of %d feeds, %d new items, %d of %d subscriptions failed, took %s",
+		fetched, len(groups), newItems, failures.failed, failures.processed, time.Since(started).Round(time.Millisecond))
 
-		return nil
-	}
+	return pendingMessages, failures.err()
+}
 
-	return pendingMessages
+// Timeout allows SubCheck to run for most of its interval, in case there are a lot of slow feeds.
+func (s SubCheck) Timeout() time.Duration {
+	return 25 * time.Minute
 }
 
-// Frequency reports that subscriptions should be checked hourly.
-func (s SubCheck) Frequency() Frequency {
+// Schedule reports that subscriptions should be checked every half-hour.
+func (s SubCheck) Schedule() Schedule {
 	return HalfHourly
 }
 
-// SubCleanup runs once a day to cleanup the growing list of posted content.
+// SubCleanup runs once a day to forget items seen a long time ago.
 type SubCleanup struct{}
 
-// Check will load the current elements of the feed and insert them as posted.
-func (s SubCleanup) Check(dbPool *pgxpool.Pool) map[string][]string {
-	rows, err := dbPool.Query(context.Background(), subList)
+// Check will remove the seen items older than they are kept for.
+func (s SubCleanup) Check(ctx context.Context, dbPool *pgxpool.Pool) ([]commands.MessageResponse, error) {
+	forgotten, err := persistent.ForgetSeenItems(ctx, dbPool)
 	if err != nil {
-		log.Println(err)
+		return nil, err
+	}
 
-		return nil
+	log.Printf("SubCleanup: forgot %d seen items", forgotten)
+
+	return nil, nil
+}
+
+// Schedule runs the sub cleanup daily, early in the morning when feeds are least likely to be checked.
+func (s SubCleanup) Schedule() Schedule {
+	return MustCron("0 4 * * *")
+}
+
+// subscription is a row of the Subscriptions table, read before any feed is fetched.
+type subscription struct {
+	id       int64
+	feedID   int64
+	title    string
+	channel  string
+	guildID  string
+	style    string
+	maxItems int
+	delivery string
+	template string
+	mentions bool
+}
+
+// feedGroup is every subscription to feeds of the same kind with the same URL (and selector), fetched only once.
+type feedGroup struct {
+	feedTarget
+	feedIDs       []int64
+	subscriptions []subscription
+}
+
+// feedTarget is what feeds in the same group have in common.
+type feedTarget struct {
+	kind     string
+	url      string
+	selector string
+}
+
+// feedResult is what checking a feed group produced.
+type feedResult struct {
+	messages []commands.MessageResponse
+	fetched  bool
+	newItems int
+	failures rowFailures
+}
+
+// loadSubscriptions reads every subscription, grouped by feed target in the order they were first seen.
+func loadSubscriptions(ctx context.Context, dbPool *pgxpool.Pool) ([]*feedGroup, error) {
+	rows, err := dbPool.Query(ctx, subList)
+	if err != nil {
+		return nil, err
 	}
-	// For each ID, map channel to pending messages, return chunk
+	defer rows.Close()
+
+	groups := []*feedGroup{}
+	byTarget := map[feedTarget]*feedGroup{}
+
 	for rows.Next() {
-		if err := processSubCleanupRow(dbPool, &rows); err != nil {
-			log.Println(err)
+		var sub subscription
+
+		var target feedTarget
+		if err := rows.Scan(
+			&sub.id,
+			&sub.feedID,
+			&target.kind,
+			&target.url,
+			&target.selector,
+			&sub.title,
+			&sub.channel,
+			&sub.guildID,
+			&sub.style,
+			&sub.maxItems,
+			&sub.delivery,
+			&sub.template,
+			&sub.mentions,
+		); err != nil {
+			return nil, err
+		}
 
-			continue
+		group, found := byTarget[target]
+		if !found {
+			group = &feedGroup{feedTarget: target}
+			byTarget[target] = group
+			groups = append(groups, group)
 		}
-	}
 
-	if err := rows.Err(); err != nil {
-		log.Println(err)
+		if len(group.feedIDs) == 0 || group.feedIDs[len(group.feedIDs)-1] != sub.feedID {
+			group.feedIDs = append(group.feedIDs, sub.feedID)
+		}
 
-		return nil
+		group.subscriptions = append(group.subscriptions, sub)
 	}
 
-	return nil
+	return groups, rows.Err()
 }
 
-// Frequency is the frequency of the sub cleanup.
-func (s SubCleanup) Frequency() Frequency {
-	return Daily
-}
+// forEachFeed calls work for every group, with at most feedWorkers running at once.
+func forEachFeed(groups []*feedGroup, work func(int, *feedGroup)) {
+	next := make(chan int)
+	wait := sync.WaitGroup{}
 
-func processSubCheckRow(dbPool *pgxpool.Pool, rows *pgx.Rows, pendingMessages *map[string][]string) error {
-	var id int64
+	for worker := 0; worker < feedWorkers; worker++ {
+		wait.Add(1)
 
-	var channel string
-	if err := (*rows).Scan(&id, &channel); err != nil {
-		return err
+		go func() {
+			defer wait.Done()
+
+			for i := range next {
+				work(i, groups[i])
+			}
+		}()
 	}
 
-	var title string
+	for i := range groups {
+		next <- i
+	}
 
-	var feedURL string
+	close(next)
+	wait.Wait()
+}
 
-	var lastItems map[string]struct{}
-	if err := dbPool.QueryRow(
-		context.Background(),
-		persistent.RSSSelect,
-		id,
-	).Scan(&title,
-		&feedURL,
-		&lastItems,
-	); err != nil {
-		return err
-	}
+// safelyCheckFeed checks a feed, recovering from a panic so that it doesn't take the other feeds (or the bot) with it.
+// Feeds are checked in their own goroutines, which the scheduler can't recover for.
+// The panic is recorded as the failure of every subscription to the feed, and nothing found in it is posted.
+func safelyCheckFeed(ctx context.Context, dbPool *pgxpool.Pool, group *feedGroup) (result feedResult) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err := commands.RecoverError(recovered)
+			log.Printf("SubCheck: checking %s failed: %s", group.url, err)
+
+			result = feedResult{}
+			result.failures.recordAll(len(group.subscriptions), err)
+		}
+	}()
 
-	parsedURL, err := url.Parse(feedURL)
+	return checkFeed(ctx, dbPool, group)
+}
+
+// checkFeed fetches the group's feed from its source, and finds the new items for each subscription to it.
+func checkFeed(ctx context.Context, dbPool *pgxpool.Pool, group *feedGroup) feedResult {
+	result := feedResult{}
+
+	source, err := sourceFor(group.kind)
 	if err != nil {
-		return err
+		result.failures.recordAll(len(group.subscriptions), err)
+
+		return result
 	}
 
-	feed, err := persistent.RefreshFeed(parsedURL)
-	if err != nil {
-		return err
+	fetched, err := source.Fetch(ctx, dbPool, group.feedIDs, group.url, group.selector)
+
+	var died *persistent.FeedDiedError
+
+	switch {
+	case errors.As(err, &died):
+		result.failures.recordAll(len(group.subscriptions),
+			firstError(feedDiedNotices(ctx, dbPool, died.IDs, &result.messages), err))
+
+		return result
+	case skippedFeed(err):
+		return result
+	case err != nil:
+		result.failures.recordAll(len(group.subscriptions), err)
+
+		return result
+	case len(fetched.Feed.Items) == 0:
+		result.failures.recordAll(len(group.subscriptions), errNoFeedItems)
+
+		return result
 	}
 
-	if len(feed.Items) == 0 {
-		return errNoFeedItems
+	result.fetched = true
+	filters := map[int64]persistent.FilterSet{}
+	delivery := &feedDelivery{dbPool: dbPool, fetched: fetched, complete: true}
+
+	err = persistent.RefreshFeedSeen(ctx, dbPool, group.feedIDs, identifyItems(source, fetched.Feed.Items, nil))
+	handler.LogErrorMsg("Failed to refresh what the feed has seen", err)
+
+	for _, sub := range group.subscriptions {
+		posted, err := checkSubscription(ctx, dbPool, source, delivery, sub, filters, &result.messages)
+		result.newItems += posted
+		result.failures.record(err)
+
+		if err != nil {
+			delivery.incomplete()
+		}
 	}
 
-	urls := findUniqueURLs(
-		persistent.ReduceItem(
-			feed.Items,
-			persistent.FetchRegex(id, dbPool),
-		),
-		lastItems,
-		title,
-		pendingMessages,
-		channel,
-	)
+	delivery.settle()
 
-	tag, err := dbPool.Exec(context.Background(), persistent.RSSUpdateLastItem, func() map[string]struct{} {
-		for desc := range lastItems {
-			(*urls)[desc] = struct{}{}
+	return result
+}
+
+// feedDelivery remembers a feed's fetch once every subscription has handled its items, and every item has been posted.
+// Until then, the feed is fetched in full every check, so the items left for later (or that failed to post) are found.
+type feedDelivery struct {
+	mutex    sync.Mutex
+	dbPool   *pgxpool.Pool
+	fetched  *persistent.Fetched
+	pending  int
+	settled  bool
+	complete bool
+}
+
+// post waits for the message to be sent, then calls delivered (if it was) before it's counted as handled.
+func (d *feedDelivery) post(message *commands.MessageResponse, delivered func() error) {
+	d.mutex.Lock()
+	d.pending++
+	d.mutex.Unlock()
+
+	message.Delivered = func(sent bool) {
+		if sent {
+			err := delivered()
+			handler.LogErrorMsg("Failed to record a posted item", err)
+			sent = err == nil
 		}
 
-		return *urls
-	}(), id)
-	handler.LogError(err)
-	log.Printf("SubCheck: %s (for ID %d)", tag, id)
+		d.mutex.Lock()
+		d.pending--
+		d.complete = d.complete && sent
+		d.mutex.Unlock()
 
-	return nil
+		d.rememberIfDone()
+	}
+}
+
+// incomplete records that some items weren't handled, so the fetch isn't remembered.
+func (d *feedDelivery) incomplete() {
+	d.mutex.Lock()
+	d.complete = false
+	d.mutex.Unlock()
 }
 
-func processSubCleanupRow(dbPool *pgxpool.Pool, rows *pgx.Rows) error {
-	var id int64
+// settle records that every subscription has been checked, so the fetch can be remembered once the posts are sent.
+func (d *feedDelivery) settle() {
+	d.mutex.Lock()
+	d.settled = true
+	d.mutex.Unlock()
 
-	var channel string
-	if err := (*rows).Scan(&id, &channel); err != nil {
-		return err
+	d.rememberIfDone()
+}
+
+func (d *feedDelivery) rememberIfDone() {
+	d.mutex.Lock()
+	done := d.settled && d.pending == 0 && d.complete
+	d.mutex.Unlock()
+
+	if !done {
+		return
 	}
 
-	var title string
+	writeCtx, cancel := persistent.PostedItemsContext()
+	defer cancel()
 
-	var feedURL string
+	handler.LogErrorMsg("Failed to remember a feed's fetch", d.fetched.Remember(writeCtx, d.dbPool))
+}
 
-	var lastItems map[string]struct{}
-	if err := dbPool.QueryRow(context.Background(),
-		persistent.RSSSelect,
-		id,
-	).Scan(&title,
-		&feedURL,
-		&lastItems,
-	); err != nil {
-		return err
+func checkSubscription(
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	source Source,
+	delivery *feedDelivery,
+	sub subscription,
+	filters map[int64]persistent.FilterSet,
+	pendingMessages *[]commands.MessageResponse,
+) (int, error) {
+	feedFilters, found := filters[sub.feedID]
+	if !found {
+		var err error
+		if feedFilters, err = persistent.FetchFilters(ctx, sub.feedID, dbPool); err != nil {
+			return 0, err
+		}
+
+		filters[sub.feedID] = feedFilters
 	}
 
-	parsedURL, err := url.Parse(feedURL)
+	items := identifyItems(source, delivery.fetched.Feed.Items, feedFilters)
+
+	seen, err := persistent.SelectSeen(ctx, dbPool, sub.feedID, sub.channel, items)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	feed, err := persistent.RefreshFeed(parsedURL)
-	if err != nil {
-		return err
+	fresh, stale, unposted := findNewItems(items, seen, sub)
+
+	writeCtx, cancel := persistent.PostedItemsContext()
+	defer cancel()
+
+	if len(stale) != 0 {
+		if _, err := persistent.MarkSeen(writeCtx, dbPool, sub.feedID, sub.channel, stale); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(fresh) == 0 {
+		return 0, nil
 	}
 
-	items := persistent.ReduceItem(feed.Items, persistent.FetchRegex(id, dbPool))
-	urls := make(map[string]struct{})
+	if sub.delivery != persistent.ImmediateDelivery {
+		if err := persistent.BufferDigest(writeCtx, dbPool, sub.id, sub.feedID, sub.channel, fresh); err != nil {
+			return 0, err
+		}
 
-	for _, item := range items {
-		urls[item.Description] = struct{}{}
+		log.Printf("SubCheck: buffered %d items for subscription %d to %d", len(fresh), sub.id, sub.feedID)
+
+		return len(fresh), nil
+	}
+
+	for _, item := range fresh {
+		*pendingMessages = append(*pendingMessages, renderNewItem(dbPool, source, delivery, sub, item))
+	}
+
+	if unposted != 0 {
+		delivery.incomplete()
+
+		*pendingMessages = append(*pendingMessages, commands.MessageResponse{
+			Message:         fmt.Sprintf(moreItemsMsg, unposted, sub.title),
+			AllowedMentions: persistent.SubscriptionMentions(false),
+			ChannelID:       sub.channel,
+			GuildID:         sub.guildID,
+		})
+	}
+
+	return len(fresh), nil
+}
+
+// identifyItems reduces the feed's items (that pass the filters), identified the way their source identifies them.
+func identifyItems(source Source, feedItems []*gofeed.Item, filters persistent.FilterSet) []persistent.RSSInfo {
+	items := persistent.ReduceItem(feedItems, filters)
+	for i, item := range items {
+		if id := source.ItemID(item.Item); len(id) != 0 {
+			items[i] = item.IdentifiedBy(id)
+		}
 	}
 
-	if _, err := dbPool.Exec(context.Background(), persistent.RSSUpdateLastItem, urls, id); err != nil {
+	return items
+}
+
+// renderNewItem builds the message posting an item to the subscription.
+// The item is only recorded as seen once it has been posted, so it is found again next check if posting fails.
+func renderNewItem(
+	dbPool *pgxpool.Pool,
+	source Source,
+	delivery *feedDelivery,
+	sub subscription,
+	item persistent.RSSInfo,
+) commands.MessageResponse {
+	rendered := source.Render(sub.title, item, sub.style, sub.template)
+	rendered.AllowedMentions = persistent.SubscriptionMentions(sub.mentions)
+	rendered.ChannelID = sub.channel
+	rendered.GuildID = sub.guildID
+
+	delivery.post(&rendered, func() error {
+		writeCtx, cancel := persistent.PostedItemsContext()
+		defer cancel()
+
+		_, err := persistent.MarkSeen(writeCtx, dbPool, sub.feedID, sub.channel, []persistent.RSSInfo{item})
+
+		return err
+	})
+
+	return rendered
+}
+
+// skippedFeed reports whether the feed simply had nothing to fetch this run.
+func skippedFeed(err error) bool {
+	return errors.Is(err, persistent.ErrFeedNotModified) ||
+		errors.Is(err, persistent.ErrFeedNotDue) ||
+		errors.Is(err, persistent.ErrFeedDead)
+}
+
+// feedDiedNotices lets every channel subscribed to the feeds know they are no longer being checked.
+func feedDiedNotices(
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	feedIDs []int64,
+	pendingMessages *[]commands.MessageResponse,
+) error {
+	rows, err := dbPool.Query(ctx, subDiedList, feedIDs)
+	if err != nil {
 		return err
 	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var channel, guildID, title string
+
+		var feedID int64
+		if err := rows.Scan(&channel, &guildID, &feedID, &title); err != nil {
+			return err
+		}
+
+		*pendingMessages = append(*pendingMessages, commands.MessageResponse{
+			Message:   fmt.Sprintf(feedDiedMsg, title, persistent.MaxFeedFailures, feedID),
+			ChannelID: channel,
+			GuildID:   guildID,
+		})
+	}
 
-	log.Printf("SubCheck: inserted %d items for %d", len(urls), id)
+	return rows.Err()
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
-func findUniqueURLs(
+// findNewItems returns the items that haven't been seen by the subscription yet, oldest first.
+// Only the subscription's maximum are returned, the rest are counted and left for the next check (which finds the
+// oldest of them). Subscriptions delivering digests get every new item back to buffer instead.
+// Items too old to have been remembered are returned separately, to be recorded as seen without being posted.
+func findNewItems(
 	items []persistent.RSSInfo,
-	lastItems map[string]struct{},
-	title string,
-	pendingMessages *map[string][]string,
-	channel string,
-) *map[string]struct{} {
-	urls := make(map[string]struct{})
-
-	for _, item := range items {
-		_, contained := lastItems[item.Description]
-		if !contained {
-			(*pendingMessages)[channel] = append((*pendingMessages)[channel],
-				fmt.Sprintf("%s\n**%s**\n%s",
-					title,
-					item.Title,
-					item.Description,
-				))
-			urls[item.Description] = struct{}{}
-
-			break
+	seen map[string]struct{},
+	sub subscription,
+) ([]persistent.RSSInfo, []persistent.RSSInfo, int) {
+	fresh := []persistent.RSSInfo{}
+	stale := []persistent.RSSInfo{}
+	unposted := 0
+
+	for _, item := range persistent.Chronological(items) {
+		if item.SeenIn(seen) {
+			continue
+		}
+
+		// Feeds can list the same item more than once
+		seen[item.Keys[0]] = struct{}{}
+
+		switch {
+		case item.Stale():
+			stale = append(stale, item)
+		case sub.delivery == persistent.ImmediateDelivery && len(fresh) == sub.maxItems:
+			unposted++
+		default:
+			fresh = append(fresh, item)
 		}
 	}
 
-	log.Printf("Identified %d new items, and %d existing ones", len(urls), len(lastItems))
+	log.Printf("Identified %d new items (%d left for later, %d too old), and %d seen ones",
+		len(fresh), unposted, len(stale), len(seen))
+
+	return fresh, stale, unposted
+}
+
+// rowFailures counts how many subscriptions couldn't be processed, so one broken feed doesn't hide the others.
+type rowFailures struct {
+	processed int
+	failed    int
+	last      error
+}
+
+func (f *rowFailures) record(err error) {
+	f.recordAll(1, err)
+}
+
+// recordAll records the same outcome for several subscriptions, e.g. when their feed couldn't be fetched.
+func (f *rowFailures) recordAll(subscriptions int, err error) {
+	f.processed += subscriptions
+
+	if err != nil {
+		log.Println(err)
+
+		f.failed += subscriptions
+		f.last = err
+	}
+}
+
+func (f *rowFailures) merge(other rowFailures) {
+	f.processed += other.processed
+	f.failed += other.failed
+
+	if other.last != nil {
+		f.last = other.last
+	}
+}
+
+func (f *rowFailures) err() error {
+	if f.failed == 0 {
+		return nil
+	}
 
-	return &urls
+	return fmt.Errorf("%d of %d subscriptions failed, most recently: %w", f.failed, f.processed, f.last)
 }
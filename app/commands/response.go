@@ -1,5 +1,7 @@
 package commands
 
+import "github.com/bwmarrin/discordgo"
+
 // MessageResponse contains information to respond to a command.
 // Idiomatic usage is to send for each message/reaction, and not to cache.
 type MessageResponse struct {
@@ -8,8 +10,22 @@ type MessageResponse struct {
 	Reaction ReactionResponse
 	// Message is the message to send
 	// It is intentionally singular
-	Message   string
-	ChannelID string
+	Message string
+	// Embed is sent with the message (which may be empty, if there is an embed)
+	Embed *discordgo.MessageEmbed
+	// File is uploaded with the message (which may be empty, if there is a file)
+	File *discordgo.File
+	// AllowedMentions limits who the message pings, if set. Otherwise everyone mentioned is pinged
+	AllowedMentions *discordgo.MessageAllowedMentions
+	ChannelID       string
+	// GuildID is the server the channel must belong to, if set. If the channel is anywhere else, nothing is sent
+	GuildID string
+	// Interaction is set if the command was invoked as a slash command, and the message should be a follow-up
+	Interaction *discordgo.Interaction
+	// Confirmation is set if the message asks a user to confirm something by reacting to it
+	Confirmation *Confirmation
+	// Delivered is called (if set) with whether the message was sent, e.g. to only record what was actually posted
+	Delivered func(sent bool)
 }
 
 // ReactionResponse contains information to add or remove reactions.
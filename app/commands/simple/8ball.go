@@ -1,6 +1,7 @@
 package simple
 
 import (
+	"context"
 	"math/rand"
 	"strings"
 
@@ -40,6 +41,7 @@ func (e EightBall) Check() error {
 
 // ProcessMessage will return an error if no arguments are provided, otherwise a random message is chosen.
 func (e EightBall) ProcessMessage(
+	_ context.Context,
 	response chan<- commands.MessageResponse,
 	m *discordgo.MessageCreate,
 ) *commands.CommandError {
@@ -61,6 +63,13 @@ func (e EightBall) CommandList() []string {
 	return []string{"8", "8ball"}
 }
 
+// Arguments returns the arguments accepted by the 8 Ball Command.
+func (e EightBall) Arguments() []commands.Argument {
+	return []commands.Argument{
+		{Name: "question", Description: "A yes/no question", Required: true},
+	}
+}
+
 // Help gives help information for the 8 Ball Command.
 func (e EightBall) Help() string {
 	return "Provides an answer to a yes/no question"
@@ -1,6 +1,7 @@
 package simple
 
 import (
+	"context"
 	"math/rand"
 	"strings"
 
@@ -19,6 +20,7 @@ func (c Choose) Check() error {
 // ProcessMessage processes a set of options to pick from,
 // selecting one at random or returning an error if none are provided.
 func (c Choose) ProcessMessage(
+	_ context.Context,
 	response chan<- commands.MessageResponse,
 	m *discordgo.MessageCreate,
 ) *commands.CommandError {
@@ -45,6 +47,13 @@ func (c Choose) CommandList() []string {
 	return []string{"choose"}
 }
 
+// Arguments returns the arguments accepted by the Choose Command.
+func (c Choose) Arguments() []commands.Argument {
+	return []commands.Argument{
+		{Name: "options", Description: "Space separated options to choose from", Required: true},
+	}
+}
+
 // Help returns the help message for the Choose Command.
 func (c Choose) Help() string {
 	return "Provides a random choice from one or more options"
@@ -1,6 +1,7 @@
 package simple
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -40,6 +41,7 @@ func (c Cowsay) Check() error {
 
 // ProcessMessage processes a message and returns a cow saying it, or an error if no message was supplied.
 func (c Cowsay) ProcessMessage(
+	ctx context.Context,
 	response chan<- commands.MessageResponse,
 	m *discordgo.MessageCreate,
 ) *commands.CommandError {
@@ -53,7 +55,7 @@ func (c Cowsay) ProcessMessage(
 	cowMsg := string([]rune(m.Content)[len(splitContent[0])+1:])
 	// OK to run user provided input.
 	/* #nosec */
-	cowsay, err := exec.Command("cowsay", "-f", cow, cowMsg).Output()
+	cowsay, err := exec.CommandContext(ctx, "cowsay", "-f", cow, cowMsg).Output()
 	if commandError := commands.CreateCommandError(
 		"Something bad happened when I asked the cow to say that...",
 		err,
@@ -73,6 +75,13 @@ func (c Cowsay) CommandList() []string {
 	return []string{"cowsay"}
 }
 
+// Arguments returns the arguments accepted by the Cowsay Command.
+func (c Cowsay) Arguments() []commands.Argument {
+	return []commands.Argument{
+		{Name: "message", Description: "What the cow should say", Required: true},
+	}
+}
+
 // Help returns the help message for the Cowsay Command.
 func (c Cowsay) Help() string {
 	return "Provides a random cow saying the provided message"
@@ -63,6 +63,7 @@ func (i Issue) Check() error {
 
 // ProcessMessage will attempt to create an issue with the given text.
 func (i Issue) ProcessMessage(
+	ctx context.Context,
 	response chan<- commands.MessageResponse,
 	m *discordgo.MessageCreate,
 ) *commands.CommandError {
@@ -90,7 +91,7 @@ func (i Issue) ProcessMessage(
 
 	label := []string{}
 
-	if strings.Fields(m.Content)[0] == "!bug" {
+	if strings.Fields(m.Content)[0] == "bug" {
 		label = append(label, bugLabelID)
 	}
 
@@ -102,7 +103,7 @@ func (i Issue) ProcessMessage(
 
 	if commandError := commands.CreateCommandError(
 		"Failed to make the issue",
-		client.Run(context.Background(), req, &issueData),
+		client.Run(ctx, req, &issueData),
 	); commandError != nil {
 		return commandError
 	}
@@ -116,11 +117,24 @@ func (i Issue) ProcessMessage(
 	return nil
 }
 
+// Permission reports the permission the Issue Command requires by default.
+// Issues are filed on the bot's repository, so only moderators can file them by default.
+func (i Issue) Permission() int64 {
+	return discordgo.PermissionManageMessages
+}
+
 // CommandList returns the invocable aliases for the Issue Command.
 func (i Issue) CommandList() []string {
 	return []string{"issue", "bug"}
 }
 
+// Arguments returns the arguments accepted by the Issue Command.
+func (i Issue) Arguments() []commands.Argument {
+	return []commands.Argument{
+		{Name: "text", Description: "Title of the issue, optionally followed by a period and a description", Required: true},
+	}
+}
+
 // Help gives help information for the Issue Command.
 func (i Issue) Help() string {
 	return "Opens a GitHub issue with the provided text.\n" +
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"quozlet.net/birbbot/app/commands"
@@ -31,6 +32,7 @@ func (s Search) Check() error {
 
 // ProcessMessage with search query and return first result.
 func (s Search) ProcessMessage(
+	ctx context.Context,
 	msgResponse chan<- commands.MessageResponse,
 	m *discordgo.MessageCreate,
 ) *commands.CommandError {
@@ -60,7 +62,7 @@ func (s Search) ProcessMessage(
 	q.Set("q", url.QueryEscape(strings.Join(splitContent[1:], " ")))
 	search.RawQuery = q.Encode()
 
-	result, err := fetchSearchResults(search)
+	result, err := fetchSearchResults(ctx, search)
 	if commandError = commands.CreateCommandError("Failed to fetch search results", err); commandError != nil {
 		return commandError
 	}
@@ -76,11 +78,24 @@ func (s Search) ProcessMessage(
 	return nil
 }
 
+// Cooldown reports how long each user waits between uses of the Search Command.
+// Every search is a request to the searx instance, which shouldn't be flooded.
+func (s Search) Cooldown() time.Duration {
+	return 10 * time.Second
+}
+
 // CommandList returns a list of aliases for the Search Command.
 func (s Search) CommandList() []string {
 	return []string{"s", "search"}
 }
 
+// Arguments returns the arguments accepted by the Search Command.
+func (s Search) Arguments() []commands.Argument {
+	return []commands.Argument{
+		{Name: "query", Description: "What to search for", Required: true},
+	}
+}
+
 // Help returns the help message for the Weather Command.
 func (s Search) Help() string {
 	return "`s`/`search` to perform a web search\n" +
@@ -99,8 +114,8 @@ type Result struct {
 	URL     string `json:"url"`
 }
 
-func fetchSearchResults(search *url.URL) (*SearchResponse, error) {
-	request, err := http.NewRequestWithContext(context.Background(), http.MethodGet, search.String(), nil)
+func fetchSearchResults(ctx context.Context, search *url.URL) (*SearchResponse, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, search.String(), nil)
 
 	if err := commands.CreateCommandError(
 		"Failure occurred while constructing request",
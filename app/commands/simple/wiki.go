@@ -29,6 +29,7 @@ func (w Wiki) Check() error {
 
 // ProcessMessage searches for a Wikipedia article by title.
 func (w Wiki) ProcessMessage(
+	ctx context.Context,
 	msgResponse chan<- commands.MessageResponse,
 	m *discordgo.MessageCreate,
 ) *commands.CommandError {
@@ -51,7 +52,7 @@ func (w Wiki) ProcessMessage(
 	}
 
 	log.Println(wikiURL)
-	response, err := http.NewRequestWithContext(context.Background(), http.MethodGet, wikiURL.String(), nil)
+	response, err := http.NewRequestWithContext(ctx, http.MethodGet, wikiURL.String(), nil)
 
 	if commandError = commands.CreateCommandError(
 		"Didn't hear back from Wikipedia about that article",
@@ -88,6 +89,13 @@ func (w Wiki) CommandList() []string {
 	return []string{"wiki"}
 }
 
+// Arguments returns the arguments accepted by the Wiki Command.
+func (w Wiki) Arguments() []commands.Argument {
+	return []commands.Argument{
+		{Name: "title", Description: "Title of the Wikipedia article", Required: true},
+	}
+}
+
 // Help returns the help message for the Wiki Command.
 func (w Wiki) Help() string {
 	return "`wiki <title>` will make a best guess attempt to find the most relevant Wikipedia article"
@@ -0,0 +1,78 @@
+package app
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"quozlet.net/birbbot/app/commands"
+	handler "quozlet.net/birbbot/util"
+)
+
+// Unanswered confirmations are treated as cancelled after this long.
+// It is shorter than the default command timeout, so the command can still report the outcome.
+const confirmationTimeout = 30 * time.Second
+
+var confirmations = &pendingConfirmations{waiting: map[string]*commands.Confirmation{}}
+
+// pendingConfirmations are confirmations waiting on a reaction, keyed by the ID of the message to react to.
+type pendingConfirmations struct {
+	mutex   sync.Mutex
+	waiting map[string]*commands.Confirmation
+}
+
+// await reactions to a message that was sent with a confirmation, giving up after the confirmationTimeout.
+func (p *pendingConfirmations) await(
+	session *discordgo.Session,
+	channelID string,
+	messageID string,
+	confirmation *commands.Confirmation,
+) {
+	p.mutex.Lock()
+	p.waiting[messageID] = confirmation
+	p.mutex.Unlock()
+
+	for _, emoji := range append(append([]string{}, confirmation.Choices...), commands.CancelEmoji) {
+		handler.LogErrorMsg("Failed to add confirmation reaction", session.MessageReactionAdd(channelID, messageID, emoji))
+	}
+
+	time.AfterFunc(confirmationTimeout, func() {
+		p.resolve(messageID, commands.Cancelled)
+	})
+}
+
+// react resolves a confirmation if the reaction is from the right user, and is one of the choices (or cancels).
+func (p *pendingConfirmations) react(r *discordgo.MessageReactionAdd) {
+	p.mutex.Lock()
+	confirmation, found := p.waiting[r.MessageID]
+	p.mutex.Unlock()
+
+	if !found || r.UserID != confirmation.UserID {
+		return
+	}
+
+	if r.Emoji.Name == commands.CancelEmoji {
+		p.resolve(r.MessageID, commands.Cancelled)
+
+		return
+	}
+
+	for choice, emoji := range confirmation.Choices {
+		if r.Emoji.Name == emoji {
+			p.resolve(r.MessageID, choice)
+
+			return
+		}
+	}
+}
+
+func (p *pendingConfirmations) resolve(messageID string, choice int) {
+	p.mutex.Lock()
+	confirmation, found := p.waiting[messageID]
+	delete(p.waiting, messageID)
+	p.mutex.Unlock()
+
+	if found {
+		confirmation.Resolve(choice)
+	}
+}
@@ -0,0 +1,210 @@
+package app
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"quozlet.net/birbbot/app/commands"
+	"quozlet.net/birbbot/app/commands/audio"
+	"quozlet.net/birbbot/app/commands/persistent"
+	handler "quozlet.net/birbbot/util"
+)
+
+// Discord rejects slash commands with longer descriptions.
+const maxDescriptionLength = 100
+
+var (
+	helpArguments = []commands.Argument{
+		{Name: "command", Description: "Command to get more information on"},
+	}
+	// Commands that don't describe their arguments accept anything that could've been typed after them.
+	defaultArguments = []commands.Argument{
+		{Name: "arguments", Description: "Everything you would type after the command"},
+	}
+)
+
+// registerApplicationCommands exposes every alias of every registered command as a slash command.
+// Audio commands are excluded, since they require the message to come from a user in voice.
+func registerApplicationCommands(s *discordgo.Session, appID string, commandMap map[string]*Command) {
+	appCommands := []*discordgo.ApplicationCommand{{
+		Name:        "help",
+		Description: "Lists all available commands",
+		Options:     buildOptions(helpArguments),
+	}}
+
+	for alias, cmd := range commandMap {
+		if _, isAudio := (*cmd).(AudioCommand); isAudio {
+			continue
+		}
+
+		appCommands = append(appCommands, &discordgo.ApplicationCommand{
+			Name:        alias,
+			Description: buildDescription(*cmd),
+			Options:     buildOptions(argumentsFor(cmd)),
+		})
+	}
+
+	registered, err := s.ApplicationCommandBulkOverwrite(appID, "", appCommands)
+	if err != nil {
+		handler.LogErrorMsg("Failed to register slash commands", err)
+
+		return
+	}
+
+	log.Printf("Registered %d slash commands", len(registered))
+}
+
+func buildDescription(cmd Command) string {
+	description := []rune(strings.SplitN(cmd.Help(), "\n", 2)[0])
+	if len(description) > maxDescriptionLength {
+		return string(description[:maxDescriptionLength-1]) + "…"
+	}
+
+	return string(description)
+}
+
+func buildOptions(arguments []commands.Argument) []*discordgo.ApplicationCommandOption {
+	options := make([]*discordgo.ApplicationCommandOption, 0, len(arguments))
+
+	for _, argument := range arguments {
+		options = append(options, &discordgo.ApplicationCommandOption{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        argument.Name,
+			Description: argument.Description,
+			Required:    argument.Required,
+		})
+	}
+
+	return options
+}
+
+func argumentsFor(cmd *Command) []commands.Argument {
+	argumentCmd, describesArguments := (*cmd).(ArgumentCommand)
+	_, hasNoArgs := (*cmd).(NoArgsCommand)
+
+	switch {
+	case describesArguments:
+		return argumentCmd.Arguments()
+	case hasNoArgs:
+		return nil
+	default:
+		return defaultArguments
+	}
+}
+
+func interactionHandler(
+	ctx context.Context,
+	s *discordgo.Session,
+	i *discordgo.InteractionCreate,
+	dbPool *pgxpool.Pool,
+	commandMap map[string]*Command,
+	commandList []string,
+	msgChannel chan commands.MessageResponse,
+	audioChannel chan *audio.Data,
+	voiceCommandChannel chan audio.VoiceCommand,
+) {
+	if i.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
+	// Commands can take longer than Discord waits for a response, so always respond later
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	}); err != nil {
+		handler.LogErrorMsg("Failed to acknowledge slash command", err)
+
+		return
+	}
+
+	relay := make(chan commands.MessageResponse)
+	done := make(chan struct{})
+
+	go relayInteractionResponses(s, i.Interaction, relay, msgChannel, done)
+
+	defer func() {
+		close(relay)
+		<-done
+	}()
+	defer recoverCommand(i.ChannelID, relay)
+
+	commandHandler(ctx,
+		s,
+		interactionMessage(i, commandMap),
+		persistent.LookupPrefix(ctx, dbPool, i.GuildID),
+		dbPool,
+		commandMap,
+		commandList,
+		relay,
+		audioChannel,
+		voiceCommandChannel,
+	)
+}
+
+// interactionMessage builds the message that would have been sent to invoke a command, so that
+// slash commands are processed exactly the same way as messages.
+func interactionMessage(i *discordgo.InteractionCreate, commandMap map[string]*Command) *discordgo.MessageCreate {
+	data := i.ApplicationCommandData()
+	values := make(map[string]string, len(data.Options))
+
+	for _, option := range data.Options {
+		values[option.Name] = option.StringValue()
+	}
+
+	arguments := helpArguments
+	if cmd, found := commandMap[data.Name]; found {
+		arguments = argumentsFor(cmd)
+	}
+
+	content := []string{data.Name}
+
+	for _, argument := range arguments {
+		if value := strings.TrimSpace(values[argument.Name]); len(value) != 0 {
+			content = append(content, value)
+		}
+	}
+
+	author := i.User
+	if i.Member != nil {
+		author = i.Member.User
+	}
+
+	return &discordgo.MessageCreate{
+		Message: &discordgo.Message{
+			ChannelID: i.ChannelID,
+			GuildID:   i.GuildID,
+			Author:    author,
+			Member:    i.Member,
+			Content:   strings.Join(content, " "),
+		},
+	}
+}
+
+// relayInteractionResponses marks responses for the interaction's channel as follow-ups.
+// If the command never responds, the deferred response is removed so the user isn't left waiting.
+func relayInteractionResponses(
+	s *discordgo.Session,
+	interaction *discordgo.Interaction,
+	relay <-chan commands.MessageResponse,
+	msgChannel chan<- commands.MessageResponse,
+	done chan<- struct{},
+) {
+	followedUp := false
+
+	for response := range relay {
+		if response.ChannelID == interaction.ChannelID {
+			response.Interaction = interaction
+			followedUp = followedUp || len(response.Message) != 0 || response.Embed != nil || response.File != nil
+		}
+		msgChannel <- response
+	}
+
+	if !followedUp {
+		handler.LogErrorMsg("Failed to remove deferred response",
+			s.InteractionResponseDelete(s.State.User.ID, interaction),
+		)
+	}
+
+	close(done)
+}
@@ -0,0 +1,242 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"quozlet.net/birbbot/app/commands"
+	"quozlet.net/birbbot/app/commands/recurring"
+	handler "quozlet.net/birbbot/util"
+)
+
+const (
+	jobRunInsert string = "INSERT INTO JobRuns(Job, Started, Duration, Messages, Error) " +
+		"VALUES ($1, $2, $3, $4, $5)"
+	jobRunCleanup string = "DELETE FROM JobRuns WHERE Started < $1"
+	jobRunLatest  string = "SELECT DISTINCT ON (Job) Job, Started, Duration, Messages, Error FROM JobRuns " +
+		"ORDER BY Job, Started DESC"
+	jobRunLatestError string = "SELECT DISTINCT ON (Job) Job, Started, Error FROM JobRuns WHERE Error IS NOT NULL " +
+		"ORDER BY Job, Started DESC"
+)
+
+const (
+	// Run history older than this is deleted.
+	jobRunRetention = 30 * 24 * time.Hour
+	// Recording a run shouldn't be prevented by the run being cancelled, but shouldn't hold up shutdown either.
+	jobRunRecordTimeout = 5 * time.Second
+)
+
+// Jobs is a Command to list the status of every recurring command.
+type Jobs struct{}
+
+type jobRun struct {
+	started  time.Time
+	duration time.Duration
+	messages int
+	err      *string
+}
+
+// Check returns nil, the JobRuns table is created by migrations.
+func (j Jobs) Check(dbPool *pgxpool.Pool) error {
+	return nil
+}
+
+// ProcessMessage will list every recurring command, with its last run, last error and next run.
+func (j Jobs) ProcessMessage(
+	ctx context.Context,
+	response chan<- commands.MessageResponse,
+	m *discordgo.MessageCreate,
+	dbPool *pgxpool.Pool,
+) *commands.CommandError {
+	statuses := scheduler.Jobs()
+	if len(statuses) == 0 {
+		response <- commands.MessageResponse{
+			ChannelID: m.ChannelID,
+			Message:   "No recurring commands are registered",
+		}
+
+		return nil
+	}
+
+	latest, commandError := latestRuns(ctx, dbPool)
+	if commandError != nil {
+		return commandError
+	}
+
+	latestErrors, commandError := latestErrors(ctx, dbPool)
+	if commandError != nil {
+		return commandError
+	}
+
+	lines := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		lines = append(lines, describeJob(status, latest[status.Name], latestErrors[status.Name]))
+	}
+
+	response <- commands.MessageResponse{
+		ChannelID: m.ChannelID,
+		Message:   strings.Join(lines, "\n\n"),
+	}
+
+	return nil
+}
+
+// Privileged reports that listing jobs always requires being able to manage the server.
+// Their errors are raw, and can name feeds (or anything else the bot does) from any server.
+func (j Jobs) Privileged(args []string) bool {
+	return true
+}
+
+// CommandList returns a list of aliases for the Jobs Command.
+func (j Jobs) CommandList() []string {
+	return []string{"jobs"}
+}
+
+// Help returns the help message for the Jobs Command.
+func (j Jobs) Help() string {
+	return "`jobs` lists every recurring job (like checking subscriptions), " +
+		"when it last ran, its last error, and when it will next run\n" +
+		"_Only users who can manage this server can use it_"
+}
+
+// recordRun saves the outcome of a recurring command.
+func recordRun(dbPool *pgxpool.Pool, name string, started time.Time, messages int, runErr error) {
+	duration := time.Since(started)
+
+	var errorMessage *string
+
+	if runErr != nil {
+		message := runErr.Error()
+		errorMessage = &message
+
+		log.Printf("%s failed after %s: %s", name, duration, runErr)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), jobRunRecordTimeout)
+	defer cancel()
+
+	_, err := dbPool.Exec(ctx, jobRunInsert, name, started, duration, messages, errorMessage)
+	handler.LogErrorMsg(fmt.Sprintf("Failed to record run of %s", name), err)
+}
+
+// JobCleanup runs once a day to remove runs that are past retention.
+// Some jobs run every minute, so runs are pruned here rather than every time one is recorded.
+type JobCleanup struct{}
+
+// Check will remove the runs older than they are kept for.
+func (j JobCleanup) Check(ctx context.Context, dbPool *pgxpool.Pool) ([]commands.MessageResponse, error) {
+	tag, err := dbPool.Exec(ctx, jobRunCleanup, time.Now().Add(-jobRunRetention))
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("JobCleanup: removed %d old job runs", tag.RowsAffected())
+
+	return nil, nil
+}
+
+// Schedule runs the job cleanup daily, alongside the sub cleanup.
+func (j JobCleanup) Schedule() recurring.Schedule {
+	return recurring.MustCron("0 4 * * *")
+}
+
+func latestRuns(ctx context.Context, dbPool *pgxpool.Pool) (map[string]jobRun, *commands.CommandError) {
+	rows, err := dbPool.Query(ctx, jobRunLatest)
+	if commandError := commands.CreateCommandError(
+		"Couldn't read the job history from the database!",
+		err,
+	); commandError != nil {
+		return nil, commandError
+	}
+	defer rows.Close()
+
+	runs := map[string]jobRun{}
+
+	for rows.Next() {
+		var name string
+
+		var run jobRun
+		if commandError := commands.CreateCommandError(
+			"An error occurred reading a certain job's history. Aborting",
+			rows.Scan(&name, &run.started, &run.duration, &run.messages, &run.err),
+		); commandError != nil {
+			return nil, commandError
+		}
+
+		runs[name] = run
+	}
+
+	return runs, commands.CreateCommandError("An error occurred fetching the job history", rows.Err())
+}
+
+func latestErrors(ctx context.Context, dbPool *pgxpool.Pool) (map[string]jobRun, *commands.CommandError) {
+	rows, err := dbPool.Query(ctx, jobRunLatestError)
+	if commandError := commands.CreateCommandError(
+		"Couldn't read the job errors from the database!",
+		err,
+	); commandError != nil {
+		return nil, commandError
+	}
+	defer rows.Close()
+
+	runs := map[string]jobRun{}
+
+	for rows.Next() {
+		var name string
+
+		var run jobRun
+		if commandError := commands.CreateCommandError(
+			"An error occurred reading a certain job's errors. Aborting",
+			rows.Scan(&name, &run.started, &run.err),
+		); commandError != nil {
+			return nil, commandError
+		}
+
+		runs[name] = run
+	}
+
+	return runs, commands.CreateCommandError("An error occurred fetching the job errors", rows.Err())
+}
+
+func describeJob(status JobStatus, latest jobRun, latestError jobRun) string {
+	description := []string{fmt.Sprintf("**%s**", status.Name)}
+
+	switch {
+	case status.Running:
+		description = append(description, "Running now")
+	case status.Next.IsZero():
+		description = append(description, "Won't run again")
+	default:
+		description = append(description, fmt.Sprintf("Next run in %s", time.Until(status.Next).Round(time.Second)))
+	}
+
+	if latest.started.IsZero() {
+		description = append(description, "Hasn't run yet")
+	} else {
+		outcome := "succeeded"
+		if latest.err != nil {
+			outcome = "failed"
+		}
+
+		description = append(description, fmt.Sprintf("Last run %s ago, %s in %s with %d messages",
+			time.Since(latest.started).Round(time.Second),
+			outcome,
+			latest.duration.Round(time.Millisecond),
+			latest.messages,
+		))
+	}
+
+	if latestError.err != nil {
+		description = append(description, fmt.Sprintf("Last error %s ago: `%s`",
+			time.Since(latestError.started).Round(time.Second),
+			*latestError.err,
+		))
+	}
+
+	return strings.Join(description, "\n")
+}
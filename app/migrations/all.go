@@ -0,0 +1,280 @@
+package migrations
+
+// all migrations, in the order they're applied. Once released, a migration must never be changed, only added after.
+//
+// The first few adopt tables that were created before migrations existed (by each command's Check),
+// so they must work whether or not the table already exists, and whatever version of it that is.
+var all = []Migration{
+	{
+		Version: 1,
+		Name:    "create prefixes",
+		Up: []string{
+			"CREATE TABLE IF NOT EXISTS Prefixes (GuildID TEXT PRIMARY KEY, Prefix TEXT NOT NULL)",
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS Prefixes",
+		},
+	},
+	{
+		Version: 2,
+		Name:    "create feeds",
+		Up: []string{
+			"CREATE TABLE IF NOT EXISTS Feeds " +
+				"(ID SERIAL PRIMARY KEY, GuildID TEXT NOT NULL, Title TEXT NOT NULL, URL TEXT NOT NULL, " +
+				"LastItems JSONB NOT NULL)",
+			// Feeds added before they belonged to a server have no GuildID, and aren't visible in any server
+			"ALTER TABLE Feeds ADD COLUMN IF NOT EXISTS GuildID TEXT NOT NULL DEFAULT ''",
+			"ALTER TABLE Feeds DROP CONSTRAINT IF EXISTS feeds_url_key",
+			"CREATE UNIQUE INDEX IF NOT EXISTS FeedsByGuild ON Feeds (GuildID, URL)",
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS Feeds",
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create filters",
+		Up: []string{
+			"CREATE TABLE IF NOT EXISTS Filters " +
+				"(ID SERIAL PRIMARY KEY, GuildID TEXT NOT NULL, Regex TEXT NOT NULL, " +
+				"FeedID INTEGER REFERENCES Feeds(ID) ON DELETE SET NULL)",
+			"ALTER TABLE Filters ADD COLUMN IF NOT EXISTS GuildID TEXT NOT NULL DEFAULT ''",
+			"ALTER TABLE Filters DROP CONSTRAINT IF EXISTS filters_regex_key",
+			"CREATE UNIQUE INDEX IF NOT EXISTS FiltersByGuild ON Filters (GuildID, Regex)",
+			// Filters used to prevent their feed from being removed, now they're unapplied instead
+			"ALTER TABLE Filters DROP CONSTRAINT IF EXISTS filters_feedid_fkey, " +
+				"ADD CONSTRAINT filters_feedid_fkey FOREIGN KEY (FeedID) REFERENCES Feeds(ID) ON DELETE SET NULL",
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS Filters",
+		},
+	},
+	{
+		Version: 4,
+		Name:    "create subscriptions",
+		Up: []string{
+			"CREATE TABLE IF NOT EXISTS Subscriptions " +
+				"(ID SERIAL PRIMARY KEY, FeedID INTEGER NOT NULL REFERENCES Feeds(ID) ON DELETE CASCADE, " +
+				"Channel TEXT NOT NULL, GuildID TEXT NOT NULL, LastItems JSONB NOT NULL)",
+			"ALTER TABLE Subscriptions ADD COLUMN IF NOT EXISTS GuildID TEXT NOT NULL DEFAULT ''",
+			// Subscriptions used to be keyed by feed, so a feed could only be posted to one channel
+			"DO $$ BEGIN " +
+				"IF NOT EXISTS (SELECT 1 FROM information_schema.columns " +
+				"WHERE table_name = 'subscriptions' AND column_name = 'id') THEN " +
+				"ALTER TABLE Subscriptions DROP CONSTRAINT subscriptions_pkey; " +
+				"ALTER TABLE Subscriptions ADD COLUMN ID SERIAL PRIMARY KEY; " +
+				"END IF; END $$",
+			// Existing subscriptions start from what has been posted for the feed
+			"ALTER TABLE Subscriptions ADD COLUMN IF NOT EXISTS LastItems JSONB",
+			"UPDATE Subscriptions SET LastItems = Feeds.LastItems FROM Feeds " +
+				"WHERE Subscriptions.FeedID = Feeds.ID AND Subscriptions.LastItems IS NULL",
+			"ALTER TABLE Subscriptions ALTER COLUMN LastItems SET NOT NULL",
+			"CREATE UNIQUE INDEX IF NOT EXISTS SubscriptionsByChannel ON Subscriptions (FeedID, Channel)",
+			// Subscriptions used to outlive their feed
+			"ALTER TABLE Subscriptions DROP CONSTRAINT IF EXISTS subscriptions_feedid_fkey, " +
+				"ADD CONSTRAINT subscriptions_feedid_fkey FOREIGN KEY (FeedID) REFERENCES Feeds(ID) ON DELETE CASCADE",
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS Subscriptions",
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create weather",
+		Up: []string{
+			"CREATE TABLE IF NOT EXISTS Weather (DiscordUserID TEXT PRIMARY KEY, Location TEXT NOT NULL)",
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS Weather",
+		},
+	},
+	{
+		Version: 6,
+		Name:    "create job runs",
+		Up: []string{
+			"CREATE TABLE IF NOT EXISTS JobRuns " +
+				"(ID SERIAL PRIMARY KEY, Job TEXT NOT NULL, Started TIMESTAMPTZ NOT NULL, Duration INTERVAL NOT NULL, " +
+				"Messages INTEGER NOT NULL, Error TEXT)",
+			"CREATE INDEX IF NOT EXISTS JobRunsByJob ON JobRuns (Job, Started DESC)",
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS JobRuns",
+		},
+	},
+	{
+		Version: 7,
+		Name:    "share filters between feeds",
+		Up: []string{
+			"CREATE TABLE FeedFilters " +
+				"(FeedID INTEGER NOT NULL REFERENCES Feeds(ID) ON DELETE CASCADE, " +
+				"FilterID INTEGER NOT NULL REFERENCES Filters(ID) ON DELETE CASCADE, PRIMARY KEY (FeedID, FilterID))",
+			"INSERT INTO FeedFilters(FeedID, FilterID) SELECT FeedID, ID FROM Filters WHERE FeedID IS NOT NULL",
+			"ALTER TABLE Filters DROP COLUMN FeedID",
+			"ALTER TABLE Filters ADD COLUMN Kind TEXT NOT NULL DEFAULT 'include' " +
+				"CHECK (Kind IN ('include', 'exclude'))",
+			"ALTER TABLE Filters ADD COLUMN Fields TEXT[] NOT NULL " +
+				"DEFAULT ARRAY['title', 'link', 'description', 'content']",
+			"DROP INDEX FiltersByGuild",
+			"CREATE UNIQUE INDEX FiltersByGuildKind ON Filters (GuildID, Kind, Fields, Regex)",
+		},
+		Down: []string{
+			"DROP INDEX FiltersByGuildKind",
+			// Only one feed per filter, and one filter per regex, can be kept
+			"ALTER TABLE Filters ADD COLUMN FeedID INTEGER REFERENCES Feeds(ID) ON DELETE SET NULL",
+			"UPDATE Filters SET FeedID = (SELECT MIN(FeedID) FROM FeedFilters WHERE FilterID = Filters.ID)",
+			"DROP TABLE FeedFilters",
+			"DELETE FROM Filters Duplicate USING Filters WHERE Duplicate.GuildID = Filters.GuildID " +
+				"AND Duplicate.Regex = Filters.Regex AND Duplicate.ID > Filters.ID",
+			"ALTER TABLE Filters DROP COLUMN Kind, DROP COLUMN Fields",
+			"CREATE UNIQUE INDEX FiltersByGuild ON Filters (GuildID, Regex)",
+		},
+	},
+	{
+		Version: 8,
+		Name:    "add subscription style",
+		Up: []string{
+			"ALTER TABLE Subscriptions ADD COLUMN Style TEXT NOT NULL DEFAULT 'embed' " +
+				"CHECK (Style IN ('embed', 'text'))",
+		},
+		Down: []string{
+			"ALTER TABLE Subscriptions DROP COLUMN Style",
+		},
+	},
+	{
+		Version: 9,
+		Name:    "add feed fetch state",
+		Up: []string{
+			"ALTER TABLE Feeds ADD COLUMN ETag TEXT NOT NULL DEFAULT '', " +
+				"ADD COLUMN LastModified TEXT NOT NULL DEFAULT '', " +
+				"ADD COLUMN UpdateInterval INTERVAL, " +
+				"ADD COLUMN NextFetch TIMESTAMPTZ, " +
+				"ADD COLUMN Failures INTEGER NOT NULL DEFAULT 0, " +
+				"ADD COLUMN Dead BOOLEAN NOT NULL DEFAULT FALSE",
+		},
+		Down: []string{
+			"ALTER TABLE Feeds DROP COLUMN ETag, DROP COLUMN LastModified, DROP COLUMN UpdateInterval, " +
+				"DROP COLUMN NextFetch, DROP COLUMN Failures, DROP COLUMN Dead",
+		},
+	},
+	{
+		Version: 10,
+		Name:    "add subscription item limit",
+		Up: []string{
+			"ALTER TABLE Subscriptions ADD COLUMN MaxItems INTEGER NOT NULL DEFAULT 5 " +
+				"CHECK (MaxItems BETWEEN 1 AND 25)",
+		},
+		Down: []string{
+			"ALTER TABLE Subscriptions DROP COLUMN MaxItems",
+		},
+	},
+	{
+		Version: 11,
+		Name:    "replace posted items with seen items",
+		Up: []string{
+			// Channel is empty for the items seen by the feed itself (when it was added, or by `rss latest`)
+			"CREATE TABLE SeenItems " +
+				"(FeedID INTEGER NOT NULL REFERENCES Feeds(ID) ON DELETE CASCADE, Channel TEXT NOT NULL, " +
+				"ItemHash TEXT NOT NULL, FirstSeen TIMESTAMPTZ NOT NULL DEFAULT now(), " +
+				"PRIMARY KEY (FeedID, Channel, ItemHash))",
+			"CREATE INDEX SeenItemsByAge ON SeenItems (FirstSeen)",
+			// Posted items were keyed by their description, which is still checked when an item has a GUID
+			"INSERT INTO SeenItems (FeedID, Channel, ItemHash) " +
+				"SELECT ID, '', encode(sha256(convert_to(Item, 'UTF8')), 'hex') " +
+				"FROM Feeds, jsonb_object_keys(LastItems) AS Item ON CONFLICT DO NOTHING",
+			"INSERT INTO SeenItems (FeedID, Channel, ItemHash) " +
+				"SELECT FeedID, Channel, encode(sha256(convert_to(Item, 'UTF8')), 'hex') " +
+				"FROM Subscriptions, jsonb_object_keys(LastItems) AS Item ON CONFLICT DO NOTHING",
+			"ALTER TABLE Feeds DROP COLUMN LastItems",
+			"ALTER TABLE Subscriptions DROP COLUMN LastItems",
+		},
+		Down: []string{
+			// Only hashes were kept, so the items of every feed will be posted again
+			"ALTER TABLE Feeds ADD COLUMN LastItems JSONB NOT NULL DEFAULT '{}'",
+			"ALTER TABLE Subscriptions ADD COLUMN LastItems JSONB NOT NULL DEFAULT '{}'",
+			"DROP TABLE SeenItems",
+		},
+	},
+	{
+		Version: 12,
+		Name:    "add subscription digests",
+		Up: []string{
+			// DigestMinute is the minute of the day (in TimeZone) that daily digests are posted at
+			"ALTER TABLE Subscriptions ADD COLUMN Delivery TEXT NOT NULL DEFAULT 'immediate' " +
+				"CHECK (Delivery IN ('immediate', 'hourly', 'daily')), " +
+				"ADD COLUMN DigestMinute INTEGER NOT NULL DEFAULT 0 CHECK (DigestMinute BETWEEN 0 AND 1439), " +
+				"ADD COLUMN TimeZone TEXT NOT NULL DEFAULT 'UTC', " +
+				"ADD COLUMN NextDigest TIMESTAMPTZ",
+			"CREATE TABLE DigestItems " +
+				"(ID SERIAL PRIMARY KEY, " +
+				"SubscriptionID INTEGER NOT NULL REFERENCES Subscriptions(ID) ON DELETE CASCADE, " +
+				"Title TEXT NOT NULL, Link TEXT NOT NULL, Added TIMESTAMPTZ NOT NULL DEFAULT now())",
+			"CREATE INDEX DigestItemsBySubscription ON DigestItems (SubscriptionID, ID)",
+		},
+		Down: []string{
+			"DROP TABLE DigestItems",
+			"ALTER TABLE Subscriptions DROP COLUMN Delivery, DROP COLUMN DigestMinute, DROP COLUMN TimeZone, " +
+				"DROP COLUMN NextDigest",
+		},
+	},
+	{
+		Version: 13,
+		Name:    "add subscription templates",
+		Up: []string{
+			// An empty template posts items in the subscription's style
+			"ALTER TABLE Subscriptions ADD COLUMN Template TEXT NOT NULL DEFAULT '', " +
+				"ADD COLUMN AllowMentions BOOLEAN NOT NULL DEFAULT FALSE",
+		},
+		Down: []string{
+			"ALTER TABLE Subscriptions DROP COLUMN Template, DROP COLUMN AllowMentions",
+		},
+	},
+	{
+		Version: 14,
+		Name:    "add feed kinds",
+		Up: []string{
+			// A feed is either an RSS (or Atom, or JSON) feed, a watched page, or watched GitHub releases
+			"ALTER TABLE Feeds ADD COLUMN Kind TEXT NOT NULL DEFAULT 'rss' " +
+				"CHECK (Kind IN ('rss', 'page', 'releases')), " +
+				"ADD COLUMN Selector TEXT NOT NULL DEFAULT ''",
+			"DROP INDEX FeedsByGuild",
+			"CREATE UNIQUE INDEX FeedsByGuild ON Feeds (GuildID, Kind, URL, Selector)",
+		},
+		Down: []string{
+			"DELETE FROM Feeds WHERE Kind <> 'rss'",
+			"DROP INDEX FeedsByGuild",
+			"CREATE UNIQUE INDEX FeedsByGuild ON Feeds (GuildID, URL)",
+			"ALTER TABLE Feeds DROP COLUMN Kind, DROP COLUMN Selector",
+		},
+	},
+	{
+		Version: 15,
+		Name:    "add command permissions",
+		Up: []string{
+			// Overrides whether a role, user or channel can use a command, instead of its default permission
+			"CREATE TABLE CommandPermissions " +
+				"(GuildID TEXT NOT NULL, Command TEXT NOT NULL, " +
+				"TargetKind TEXT NOT NULL CHECK (TargetKind IN ('role', 'user', 'channel')), " +
+				"TargetID TEXT NOT NULL, Allow BOOLEAN NOT NULL, " +
+				"PRIMARY KEY (GuildID, Command, TargetKind, TargetID))",
+		},
+		Down: []string{
+			"DROP TABLE CommandPermissions",
+		},
+	},
+	{
+		Version: 16,
+		Name:    "add seen item last seen",
+		Up: []string{
+			// Items are forgotten once they've left their feed, rather than once they're old
+			"ALTER TABLE SeenItems ADD COLUMN LastSeen TIMESTAMPTZ NOT NULL DEFAULT now()",
+			"UPDATE SeenItems SET LastSeen = FirstSeen",
+			"DROP INDEX SeenItemsByAge",
+			"CREATE INDEX SeenItemsByAge ON SeenItems (LastSeen)",
+		},
+		Down: []string{
+			"DROP INDEX SeenItemsByAge",
+			"ALTER TABLE SeenItems DROP COLUMN LastSeen",
+			"CREATE INDEX SeenItemsByAge ON SeenItems (FirstSeen)",
+		},
+	},
+}
@@ -0,0 +1,196 @@
+// Package migrations versions the database schema, so it can be created and changed in order.
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Migration changes the schema from the previous version to this one (Up), and back again (Down).
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+const (
+	versionTableDefinition string = "CREATE TABLE IF NOT EXISTS schema_migrations " +
+		"(Version INTEGER PRIMARY KEY, Name TEXT NOT NULL, AppliedAt TIMESTAMPTZ NOT NULL DEFAULT NOW())"
+	versionList     string = "SELECT Version FROM schema_migrations"
+	versionInsert   string = "INSERT INTO schema_migrations(Version, Name) VALUES ($1, $2)"
+	versionDelete   string = "DELETE FROM schema_migrations WHERE Version = $1"
+	migrationLock   string = "SELECT pg_advisory_lock($1)"
+	migrationUnlock string = "SELECT pg_advisory_unlock($1)"
+)
+
+// Held while migrating, so that two instances starting at once don't both apply the same migration.
+const lockKey = 0x62697262
+
+// Latest returns the version the schema will be at once every migration is applied.
+func Latest() int {
+	return all[len(all)-1].Version
+}
+
+// Up applies every migration that hasn't been applied yet, in order.
+func Up(ctx context.Context, dbPool *pgxpool.Pool) error {
+	return withLock(ctx, dbPool, func(conn *pgxpool.Conn, applied map[int]struct{}) error {
+		for _, migration := range all {
+			if _, isApplied := applied[migration.Version]; isApplied {
+				continue
+			}
+
+			if err := apply(ctx, conn, migration.Version, migration.Up, func(tx pgx.Tx) error {
+				_, err := tx.Exec(ctx, versionInsert, migration.Version, migration.Name)
+
+				return err
+			}); err != nil {
+				return fmt.Errorf("migrating up to %d (%s): %w", migration.Version, migration.Name, err)
+			}
+
+			log.Printf("Migrations: applied %d (%s)", migration.Version, migration.Name)
+		}
+
+		return nil
+	})
+}
+
+// Down reverts every applied migration after the target version, newest first.
+func Down(ctx context.Context, dbPool *pgxpool.Pool, target int) error {
+	return withLock(ctx, dbPool, func(conn *pgxpool.Conn, applied map[int]struct{}) error {
+		for i := len(all) - 1; i >= 0 && all[i].Version > target; i-- {
+			migration := all[i]
+			if _, isApplied := applied[migration.Version]; !isApplied {
+				continue
+			}
+
+			if err := apply(ctx, conn, migration.Version, migration.Down, func(tx pgx.Tx) error {
+				_, err := tx.Exec(ctx, versionDelete, migration.Version)
+
+				return err
+			}); err != nil {
+				return fmt.Errorf("migrating down from %d (%s): %w", migration.Version, migration.Name, err)
+			}
+
+			log.Printf("Migrations: reverted %d (%s)", migration.Version, migration.Name)
+		}
+
+		return nil
+	})
+}
+
+// withLock validates the migrations, then runs the function holding the migration lock,
+// with the set of versions that were applied when the lock was acquired.
+func withLock(
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	migrate func(*pgxpool.Conn, map[int]struct{}) error,
+) error {
+	if err := validate(); err != nil {
+		return err
+	}
+
+	conn, err := dbPool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, migrationLock, lockKey); err != nil {
+		return err
+	}
+
+	defer func() {
+		// The lock is held by the connection, so it must be released even if the context was cancelled
+		if _, err := conn.Exec(context.Background(), migrationUnlock, lockKey); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	if _, err := conn.Exec(ctx, versionTableDefinition); err != nil {
+		return err
+	}
+
+	applied, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	return migrate(conn, applied)
+}
+
+// apply runs the statements of a migration and records it, all in one transaction.
+func apply(
+	ctx context.Context,
+	conn *pgxpool.Conn,
+	version int,
+	statements []string,
+	record func(pgx.Tx) error,
+) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	// Rolling back after committing does nothing
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			log.Printf("Migrations: failed to roll back %d: %s", version, err)
+		}
+	}()
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	if err := record(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func appliedVersions(ctx context.Context, conn *pgxpool.Conn) (map[int]struct{}, error) {
+	rows, err := conn.Query(ctx, versionList)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]struct{}{}
+
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+
+		applied[version] = struct{}{}
+	}
+
+	return applied, rows.Err()
+}
+
+// validate that versions are positive and strictly increasing, so the order migrations are applied in is clear.
+func validate() error {
+	previous := 0
+
+	for _, migration := range all {
+		if migration.Version <= previous {
+			return fmt.Errorf("migration %d (%s) must have a version greater than %d",
+				migration.Version,
+				migration.Name,
+				previous)
+		}
+
+		previous = migration.Version
+	}
+
+	return nil
+}
@@ -1,6 +1,8 @@
 package app
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -30,8 +32,19 @@ type msgInfo struct {
 	voiceCommandChannel chan audio.VoiceCommand
 }
 
-func processCommand(discord discordInfo, msg msgInfo, dbPool *pgxpool.Pool) {
+// Unless a command is a TimedCommand, it is cancelled after this long.
+const defaultCommandTimeout = time.Minute
+
+var (
+	errCommandTimedOut = commands.NewError("That took too long, so I gave up. Try again later?")
+	errShuttingDown    = commands.NewError("I'm shutting down, so I stopped processing that. Try again later")
+)
+
+func processCommand(ctx context.Context, discord discordInfo, msg msgInfo, dbPool *pgxpool.Pool) {
 	command := msg.handler
+
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout(*command, defaultCommandTimeout))
+	defer cancel()
 	msg.msgChannel <- commands.MessageResponse{
 		ChannelID: discord.message.ChannelID,
 		Reaction: commands.ReactionResponse{
@@ -50,7 +63,8 @@ func processCommand(discord discordInfo, msg msgInfo, dbPool *pgxpool.Pool) {
 		}
 	}()
 
-	if err := processMessage(dbPool, command, msg, discord); err != nil {
+	if err := safelyProcessMessage(ctx, dbPool, command, msg, discord); err != nil {
+		err = cancellationError(ctx, err)
 		log.Printf("An error occurred processing \"%s\"", discord.message.Content)
 		msg.msgChannel <- commands.MessageResponse{
 			ChannelID: discord.message.ChannelID,
@@ -66,13 +80,61 @@ func processCommand(discord discordInfo, msg msgInfo, dbPool *pgxpool.Pool) {
 	}
 }
 
+// commandTimeout returns how long a command (or RecurringCommand) may run.
+func commandTimeout(cmd interface{}, fallback time.Duration) time.Duration {
+	if timedCmd, isTimed := cmd.(TimedCommand); isTimed {
+		return timedCmd.Timeout()
+	}
+
+	return fallback
+}
+
+// cancellationError replaces the error of a command that was cancelled.
+// Whatever the command failed with is most likely a side effect of the cancellation, so it isn't useful to the user.
+func cancellationError(ctx context.Context, err *commands.CommandError) *commands.CommandError {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return errCommandTimedOut
+	case errors.Is(ctx.Err(), context.Canceled):
+		return errShuttingDown
+	default:
+		return err
+	}
+}
+
+// safelyProcessMessage processes a message, converting a panic into a CommandError.
+func safelyProcessMessage(
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	command *Command,
+	msg msgInfo,
+	discord discordInfo,
+) (commandError *commands.CommandError) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			commandError = commands.RecoverError(recovered)
+		}
+	}()
+
+	return processMessage(ctx, dbPool, command, msg, discord)
+}
+
+// recoverCommand must be deferred, and reports a panic to the channel instead of crashing.
+func recoverCommand(channelID string, msgChannel chan<- commands.MessageResponse) {
+	if recovered := recover(); recovered != nil {
+		msgChannel <- commands.MessageResponse{
+			ChannelID: channelID,
+			Message:   commands.RecoverError(recovered).Error(),
+		}
+	}
+}
+
+// handleAudioCommandCommand enqueues the audio the command returns, if the user is in a voice channel to play it in.
 func handleAudioCommandCommand(
 	s *discordgo.Session,
 	msg *discordgo.MessageCreate,
-	cmd *AudioCommand,
-	responseChannel chan<- commands.MessageResponse,
+	processAudio func() (*audio.Data, *commands.CommandError),
 	audioChannel chan<- *audio.Data,
-	voiceCommandChannel chan<- audio.VoiceCommand,
 ) *commands.CommandError {
 	var commandError *commands.CommandError
 
@@ -91,7 +153,7 @@ func handleAudioCommandCommand(
 
 	for _, voiceState := range guild.VoiceStates {
 		if voiceState.UserID == msg.Author.ID {
-			data, err := (*cmd).ProcessMessage(responseChannel, voiceCommandChannel, msg)
+			data, err := processAudio()
 			if err != nil {
 				return err
 			}
@@ -112,17 +174,24 @@ func handleAudioCommandCommand(
 	return commands.NewError("You must be in a voice channel to play audio")
 }
 
-func processMessage(dbPool *pgxpool.Pool, command *Command, msg msgInfo, discord discordInfo) *commands.CommandError {
+func processMessage(
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	command *Command,
+	msg msgInfo,
+	discord discordInfo,
+) *commands.CommandError {
 	simpleCmd, isSimple := (*command).(SimpleCommand)
 	noArgsCmd, hasNoArgs := (*command).(NoArgsCommand)
 	persistentCmd, isPersistent := (*command).(PersistentCommand)
 	audioCmd, isAudio := (*command).(AudioCommand)
+	playableCmd, isPlayable := (*command).(PlayableCommand)
 
 	switch {
 	case isSimple:
-		return simpleCmd.ProcessMessage(msg.msgChannel, discord.message)
+		return simpleCmd.ProcessMessage(ctx, msg.msgChannel, discord.message)
 	case hasNoArgs:
-		responses, err := noArgsCmd.ProcessMessage()
+		responses, err := noArgsCmd.ProcessMessage(ctx)
 		if err != nil {
 			return err
 		}
@@ -135,18 +204,26 @@ func processMessage(dbPool *pgxpool.Pool, command *Command, msg msgInfo, discord
 		}
 
 		return nil
+	case isPlayable && playableCmd.Playable(strings.Fields(strings.ToLower(discord.message.Content))[1:]):
+		return handleAudioCommandCommand(discord.session,
+			discord.message,
+			func() (*audio.Data, *commands.CommandError) {
+				return playableCmd.ProcessAudio(ctx, msg.msgChannel, discord.message, dbPool)
+			},
+			msg.audioChannel,
+		)
 	case isPersistent:
-		return persistentCmd.ProcessMessage(msg.msgChannel, discord.message, dbPool)
+		return persistentCmd.ProcessMessage(ctx, msg.msgChannel, discord.message, dbPool)
 	case isAudio:
 		return handleAudioCommandCommand(discord.session,
 			discord.message,
-			&audioCmd,
-			msg.msgChannel,
+			func() (*audio.Data, *commands.CommandError) {
+				return audioCmd.ProcessMessage(msg.msgChannel, msg.voiceCommandChannel, discord.message)
+			},
 			msg.audioChannel,
-			msg.voiceCommandChannel,
 		)
 	default:
-		log.Fatalf("Got %s, an invalid command!"+
+		log.Printf("Got %s, an invalid command!"+
 			" This is most likely from introducing a new command variant but failing to handle the interface above",
 			reflect.TypeOf(*command).Name(),
 		)
@@ -155,7 +232,10 @@ func processMessage(dbPool *pgxpool.Pool, command *Command, msg msgInfo, discord
 	}
 }
 
-const audioActionReattempts = 20
+const (
+	audioActionReattempts = 20
+	audioPollInterval     = 250 * time.Millisecond
+)
 
 type currentAudioContainer struct {
 	voiceConnection  *discordgo.VoiceConnection
@@ -168,11 +248,15 @@ func waitForAudio(
 	audioChannel <-chan *audio.Data,
 	messageChannel chan<- commands.MessageResponse,
 	voiceCommandChannel chan audio.VoiceCommand,
+	stop <-chan struct{},
+	stopped chan<- struct{},
 ) {
 	queue := make([]*audio.Data, 0)
 	mutex := &sync.Mutex{}
 	currentAudio := currentAudioContainer{}
 
+	defer close(stopped)
+
 	go controlCurrentStream(voiceCommandChannel, messageChannel, mutex, &currentAudio, &queue)
 	go queueNewAudio(&queue, mutex, audioChannel)
 
@@ -185,7 +269,12 @@ func waitForAudio(
 		if len(queue) == 0 {
 			mutex.Unlock()
 
-			continue
+			select {
+			case <-stop:
+				return
+			case <-time.After(audioPollInterval):
+				continue
+			}
 		}
 
 		currentAudio.currentData = queue[0]
@@ -203,7 +292,14 @@ func waitForAudio(
 			continue
 		}
 
-		err = <-done
+		select {
+		case err = <-done:
+		case <-stop:
+			stopAudio(&currentAudio, &queue, mutex)
+
+			return
+		}
+
 		if err != io.EOF {
 			handleNonDisconnectError(&currentAudio, err, &queue, mutex)
 		}
@@ -224,6 +320,32 @@ func waitForAudio(
 	}
 }
 
+// stopAudio leaves voice while audio is playing, removing any audio that was cached as a file.
+func stopAudio(currentAudio *currentAudioContainer, queue *[]*audio.Data, mutex *sync.Mutex) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if currentAudio.voiceConnection != nil {
+		handler.LogErrorMsg("Failed to stop speaking", setSpeaking(currentAudio.voiceConnection, false))
+		handler.LogErrorMsg("Failed to leave voice", leaveVoice(currentAudio.voiceConnection))
+
+		currentAudio.voiceConnection = nil
+
+		audio.SetInVoice(false)
+	}
+
+	for _, data := range *queue {
+		if data == currentAudio.currentData {
+			// The audio source being played is locked until cleanup
+			handler.LogErrorMsg("Failed to cleanup", data.Cleanup())
+		} else {
+			handler.LogErrorMsg("Failed to remove cached audio", data.RemoveCache())
+		}
+	}
+
+	*queue = nil
+}
+
 func queueNewAudio(queue *[]*audio.Data, mutex *sync.Mutex, audioChannel <-chan *audio.Data) {
 	for audioData := range audioChannel {
 		mutex.Lock()
@@ -0,0 +1,546 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"quozlet.net/birbbot/app/commands"
+	handler "quozlet.net/birbbot/util"
+)
+
+const (
+	permissionSelect string = "SELECT TargetKind, TargetID, Allow FROM CommandPermissions " +
+		"WHERE GuildID = $1 AND Command = $2"
+	permissionList string = "SELECT Command, TargetKind, TargetID, Allow FROM CommandPermissions " +
+		"WHERE GuildID = $1 ORDER BY Command, TargetKind, TargetID"
+	permissionUpsert string = "INSERT INTO CommandPermissions (GuildID, Command, TargetKind, TargetID, Allow) " +
+		"VALUES ($1, $2, $3, $4, $5) ON CONFLICT (GuildID, Command, TargetKind, TargetID) DO UPDATE SET Allow = $5"
+	permissionDelete string = "DELETE FROM CommandPermissions " +
+		"WHERE GuildID = $1 AND Command = $2 AND ($3 = '' OR (TargetKind = $3 AND TargetID = $4))"
+)
+
+// What a permission override applies to.
+const (
+	roleTarget    = "role"
+	userTarget    = "user"
+	channelTarget = "channel"
+)
+
+// Administrators (and whoever can manage the server) can always use every command, so they can't lock themselves out.
+const adminPermissions = discordgo.PermissionAdministrator | discordgo.PermissionManageServer
+
+// permissionNames describes the permissions commands require by default.
+var permissionNames = map[int64]string{
+	discordgo.PermissionManageChannels:   "Manage Channels",
+	discordgo.PermissionManageMessages:   "Manage Messages",
+	discordgo.PermissionManageServer:     "Manage Server",
+	discordgo.PermissionVoiceMoveMembers: "Move Members",
+}
+
+// registeredCommands is every command by alias, so overrides can be validated. It is set once commands are discovered.
+var registeredCommands map[string]*Command
+
+// permissionOverride allows or denies a command to a role, user or channel.
+type permissionOverride struct {
+	kind     string
+	targetID string
+	allow    bool
+}
+
+// Perms is a Command to override who can use commands in a server.
+type Perms struct{}
+
+// Check returns nil, the CommandPermissions table is created by migrations.
+func (p Perms) Check(dbPool *pgxpool.Pool) error {
+	return nil
+}
+
+// ProcessMessage lists, adds or removes the overrides of the server the message was sent in.
+func (p Perms) ProcessMessage(
+	ctx context.Context,
+	response chan<- commands.MessageResponse,
+	m *discordgo.MessageCreate,
+	dbPool *pgxpool.Pool,
+) *commands.CommandError {
+	if len(m.GuildID) == 0 {
+		return commands.NewError("Permissions belong to a server, so this only works in one")
+	}
+
+	args := strings.Fields(m.Content)[1:]
+	if len(args) == 0 {
+		return listOverrides(ctx, response, m, dbPool)
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "list":
+		return listOverrides(ctx, response, m, dbPool)
+	case "allow", "deny":
+		return setOverride(ctx, response, m, args, dbPool)
+	case "reset":
+		return resetOverrides(ctx, response, m, args, dbPool)
+	default:
+		return commands.NewError(fmt.Sprintf("Not sure what `%s` means, see `help perms`", args[0]))
+	}
+}
+
+func listOverrides(
+	ctx context.Context,
+	response chan<- commands.MessageResponse,
+	m *discordgo.MessageCreate,
+	dbPool *pgxpool.Pool,
+) *commands.CommandError {
+	rows, err := dbPool.Query(ctx, permissionList, m.GuildID)
+	if commandError := commands.CreateCommandError(
+		"Couldn't look up this server's permissions",
+		err,
+	); commandError != nil {
+		return commandError
+	}
+	defer rows.Close()
+
+	lines := []string{}
+
+	for rows.Next() {
+		var command string
+
+		var override permissionOverride
+		if err := rows.Scan(&command, &override.kind, &override.targetID, &override.allow); err != nil {
+			return commands.CreateCommandError("Couldn't read this server's permissions", err)
+		}
+
+		verb := "denied to"
+		if override.allow {
+			verb = "allowed for"
+		}
+
+		lines = append(lines, fmt.Sprintf("`%s` is %s %s", command, verb, mentionTarget(override)))
+	}
+
+	if commandError := commands.CreateCommandError(
+		"Couldn't read this server's permissions",
+		rows.Err(),
+	); commandError != nil {
+		return commandError
+	}
+
+	message := "Every command uses its default permission, see `help <command>`"
+	if len(lines) != 0 {
+		message = strings.Join(lines, "\n")
+	}
+	response <- commands.MessageResponse{
+		ChannelID:       m.ChannelID,
+		Message:         message,
+		AllowedMentions: &discordgo.MessageAllowedMentions{Parse: []discordgo.AllowedMentionType{}},
+	}
+
+	return nil
+}
+
+func setOverride(
+	ctx context.Context,
+	response chan<- commands.MessageResponse,
+	m *discordgo.MessageCreate,
+	args []string,
+	dbPool *pgxpool.Pool,
+) *commands.CommandError {
+	if len(args) < 3 {
+		return commands.NewError("Which command, and for who? e.g. `perms allow sub @Moderators`")
+	}
+
+	command, commandError := overridableCommand(args[1])
+	if commandError != nil {
+		return commandError
+	}
+
+	override, commandError := parseTarget(args[2], m.GuildID)
+	if commandError != nil {
+		return commandError
+	}
+
+	override.allow = strings.ToLower(args[0]) == "allow"
+
+	_, err := dbPool.Exec(ctx, permissionUpsert, m.GuildID, command, override.kind, override.targetID, override.allow)
+	if commandError := commands.CreateCommandError(
+		"Couldn't save that permission, try again later",
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	verb := "denied to"
+	if override.allow {
+		verb = "allowed for"
+	}
+
+	log.Printf("Perms: %s %s %s %s in %s", command, args[0], override.kind, override.targetID, m.GuildID)
+	response <- commands.MessageResponse{
+		ChannelID:       m.ChannelID,
+		Message:         fmt.Sprintf("`%s` is now %s %s", command, verb, mentionTarget(override)),
+		AllowedMentions: &discordgo.MessageAllowedMentions{Parse: []discordgo.AllowedMentionType{}},
+	}
+
+	return nil
+}
+
+func resetOverrides(
+	ctx context.Context,
+	response chan<- commands.MessageResponse,
+	m *discordgo.MessageCreate,
+	args []string,
+	dbPool *pgxpool.Pool,
+) *commands.CommandError {
+	if len(args) < 2 {
+		return commands.NewError("Which command? e.g. `perms reset sub` or `perms reset sub @Moderators`")
+	}
+
+	command, commandError := overridableCommand(args[1])
+	if commandError != nil {
+		return commandError
+	}
+
+	// Without a target, every override of the command is removed
+	override := permissionOverride{}
+	if len(args) > 2 {
+		if override, commandError = parseTarget(args[2], m.GuildID); commandError != nil {
+			return commandError
+		}
+	}
+
+	tag, err := dbPool.Exec(ctx, permissionDelete, m.GuildID, command, override.kind, override.targetID)
+	if commandError := commands.CreateCommandError(
+		"Couldn't reset that permission, try again later",
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	message := fmt.Sprintf("Removed %d overrides, `%s` uses its default permission for them again",
+		tag.RowsAffected(), command)
+	if tag.RowsAffected() == 0 {
+		message = "There was nothing to reset"
+	}
+	response <- commands.MessageResponse{
+		ChannelID: m.ChannelID,
+		Message:   message,
+	}
+
+	return nil
+}
+
+// overridableCommand finds the name a command's overrides are saved under, i.e. its first alias.
+func overridableCommand(alias string) (string, *commands.CommandError) {
+	cmd, found := registeredCommands[strings.ToLower(alias)]
+	if !found {
+		return "", commands.NewError(fmt.Sprintf("`%s` isn't a command I know", alias))
+	}
+
+	if _, isPerms := (*cmd).(Perms); isPerms {
+		return "", commands.NewError("Only users who can manage this server can change permissions, " +
+			"and that can't be changed")
+	}
+
+	return commandName(cmd), nil
+}
+
+// parseTarget reads a mention of a role, user or channel (or `everyone`, which is the server's default role).
+func parseTarget(mention string, guildID string) (permissionOverride, *commands.CommandError) {
+	switch {
+	case strings.EqualFold(mention, "everyone") || mention == "@everyone":
+		return permissionOverride{kind: roleTarget, targetID: guildID}, nil
+	case strings.HasPrefix(mention, "<@&") && strings.HasSuffix(mention, ">"):
+		return permissionOverride{kind: roleTarget, targetID: strings.Trim(mention, "<@&>")}, nil
+	case strings.HasPrefix(mention, "<@") && strings.HasSuffix(mention, ">"):
+		return permissionOverride{kind: userTarget, targetID: strings.Trim(mention, "<@!>")}, nil
+	case strings.HasPrefix(mention, "<#") && strings.HasSuffix(mention, ">"):
+		return permissionOverride{kind: channelTarget, targetID: strings.Trim(mention, "<#>")}, nil
+	default:
+		return permissionOverride{}, commands.NewError(
+			fmt.Sprintf("%s isn't a role, user or channel. Mention one (or use `everyone`)", mention))
+	}
+}
+
+func mentionTarget(override permissionOverride) string {
+	switch override.kind {
+	case roleTarget:
+		return "<@&" + override.targetID + ">"
+	case userTarget:
+		return "<@" + override.targetID + ">"
+	default:
+		return "<#" + override.targetID + ">"
+	}
+}
+
+// commandName is the name a command is known by, whichever alias was used.
+func commandName(cmd *Command) string {
+	return (*cmd).CommandList()[0]
+}
+
+// Privileged reports that changing permissions requires elevated permissions, but listing them does not.
+func (p Perms) Privileged(args []string) bool {
+	return len(args) != 0 && args[0] != "list"
+}
+
+// CommandList returns a list of aliases for the Perms Command.
+func (p Perms) CommandList() []string {
+	return []string{"perms", "permissions"}
+}
+
+// Help returns the help message for the Perms Command.
+func (p Perms) Help() string {
+	return "Changes who can use a command in this server, instead of the permission it requires by default\n" +
+		"- `perms` lists every change\n" +
+		"- `perms allow <command> <@role|@user|#channel|everyone>` lets them use the command\n" +
+		"- `perms deny <command> <@role|@user|#channel|everyone>` stops them using the command\n" +
+		"- `perms reset <command> [@role|@user|#channel|everyone]` removes the changes to the command " +
+		"(or only the one for them)\n" +
+		"Changes for a user come first, then for the channel, then for their roles (where a denial beats an allowance). " +
+		"Commands naming another channel (like `sub <id> #channel`) have to be allowed in that channel too. " +
+		"Users who can manage this server can always use every command, and only they can change permissions"
+}
+
+// checkPermission reports why the author of the message can't use the command, or nil if they can.
+// Direct messages have no permissions, so every command can be used in them.
+func checkPermission(
+	ctx context.Context,
+	s *discordgo.Session,
+	m *discordgo.MessageCreate,
+	dbPool *pgxpool.Pool,
+	prefix string,
+	args []string,
+	cmd *Command,
+) *commands.CommandError {
+	if len(m.GuildID) == 0 {
+		return nil
+	}
+
+	permissions, err := memberPermissions(s, m)
+	if commandError := commands.CreateCommandError(
+		"Couldn't check if you're allowed to do that",
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	if permissions&adminPermissions != 0 {
+		return nil
+	}
+
+	overrides, err := selectOverrides(ctx, dbPool, m.GuildID, commandName(cmd))
+	if commandError := commands.CreateCommandError(
+		"Couldn't check if you're allowed to do that",
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	name := BuildCommandName(prefix, commandName(cmd))
+
+	if override, found := resolveOverride(overrides, m.Author.ID, m.ChannelID, memberRoles(s, m)); found {
+		if override.allow {
+			return nil
+		}
+
+		switch override.kind {
+		case userTarget:
+			return commands.NewError(fmt.Sprintf("You aren't allowed to use `%s` in this server", name))
+		case channelTarget:
+			return commands.NewError(fmt.Sprintf("`%s` can't be used in this channel", name))
+		default:
+			return commands.NewError(fmt.Sprintf("One of your roles isn't allowed to use `%s`", name))
+		}
+	}
+
+	required := requiredPermission(cmd, args)
+	if permissions&required != required {
+		return commands.NewError(fmt.Sprintf("Using `%s` requires the %s permission", name, permissionName(required)))
+	}
+
+	return nil
+}
+
+// checkTargetPermission reports why the author can't use the command on the channel its arguments name, or nil if
+// they can. Otherwise being able to use it in one channel would be enough to have feeds posted into any other.
+// They have to be able to send messages there too, since whatever is posted is posted on their behalf.
+func checkTargetPermission(
+	ctx context.Context,
+	s *discordgo.Session,
+	m *discordgo.MessageCreate,
+	dbPool *pgxpool.Pool,
+	prefix string,
+	args []string,
+	cmd *Command,
+) *commands.CommandError {
+	channelCmd, isChannelCmd := (*cmd).(ChannelCommand)
+	if !isChannelCmd || len(m.GuildID) == 0 {
+		return nil
+	}
+
+	channelID, _ := channelCmd.TargetChannel(args)
+	if len(channelID) == 0 || channelID == m.ChannelID {
+		return nil
+	}
+
+	// Channels that are gone (which only commands that don't need them to exist are left with) have no permissions
+	if _, err := findChannel(s, channelID); err != nil {
+		return nil
+	}
+
+	inTarget := *m.Message
+	inTarget.ChannelID = channelID
+	target := &discordgo.MessageCreate{Message: &inTarget}
+
+	if commandError := checkPermission(ctx, s, target, dbPool, prefix, args, cmd); commandError != nil {
+		return commands.NewError(fmt.Sprintf("In <#%s>: %s", channelID, commandError.Error()))
+	}
+
+	permissions, err := memberPermissions(s, target)
+	if commandError := commands.CreateCommandError(
+		"Couldn't check if you're allowed to do that",
+		err,
+	); commandError != nil {
+		return commandError
+	}
+
+	if permissions&(adminPermissions|discordgo.PermissionSendMessages) == 0 {
+		return commands.NewError(fmt.Sprintf("You can't send messages in <#%s>, so I won't post there for you",
+			channelID))
+	}
+
+	return nil
+}
+
+// resolveOverride finds the override that applies: the user's, then the channel's, then their roles'.
+// If their roles disagree, denying wins.
+func resolveOverride(
+	overrides []permissionOverride,
+	userID, channelID string,
+	roles []string,
+) (permissionOverride, bool) {
+	hasRole := make(map[string]struct{}, len(roles))
+	for _, role := range roles {
+		hasRole[role] = struct{}{}
+	}
+
+	var roleOverride *permissionOverride
+
+	for _, kind := range []string{userTarget, channelTarget, roleTarget} {
+		for i, override := range overrides {
+			if override.kind != kind {
+				continue
+			}
+
+			switch kind {
+			case userTarget:
+				if override.targetID == userID {
+					return override, true
+				}
+			case channelTarget:
+				if override.targetID == channelID {
+					return override, true
+				}
+			case roleTarget:
+				if _, found := hasRole[override.targetID]; found && (roleOverride == nil || !override.allow) {
+					roleOverride = &overrides[i]
+				}
+			}
+		}
+	}
+
+	if roleOverride != nil {
+		return *roleOverride, true
+	}
+
+	return permissionOverride{}, false
+}
+
+func selectOverrides(ctx context.Context, dbPool *pgxpool.Pool, guildID, command string) ([]permissionOverride, error) {
+	rows, err := dbPool.Query(ctx, permissionSelect, guildID, command)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := []permissionOverride{}
+
+	for rows.Next() {
+		var override permissionOverride
+		if err := rows.Scan(&override.kind, &override.targetID, &override.allow); err != nil {
+			return nil, err
+		}
+
+		overrides = append(overrides, override)
+	}
+
+	return overrides, rows.Err()
+}
+
+// requiredPermission is the permission the command requires by default when run with the arguments, if any.
+func requiredPermission(cmd *Command, args []string) int64 {
+	if partlyCmd, isPartly := (*cmd).(PartlyPermissionedCommand); isPartly && !partlyCmd.Permissioned(args) {
+		return 0
+	}
+
+	if permissionedCmd, isPermissioned := (*cmd).(PermissionedCommand); isPermissioned {
+		return permissionedCmd.Permission()
+	}
+
+	return 0
+}
+
+func permissionName(permission int64) string {
+	if name, found := permissionNames[permission]; found {
+		return name
+	}
+
+	names := []string{}
+
+	for bit, name := range permissionNames {
+		if permission&bit != 0 {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return fmt.Sprintf("%#x", permission)
+	}
+
+	sort.Strings(names)
+
+	return strings.Join(names, " and ")
+}
+
+// memberPermissions are the author's permissions in the channel the message was sent in.
+func memberPermissions(s *discordgo.Session, m *discordgo.MessageCreate) (int64, error) {
+	permissions, err := s.State.UserChannelPermissions(m.Author.ID, m.ChannelID)
+	if err != nil {
+		// Not everything is guaranteed to be cached, so ask Discord directly
+		return s.UserChannelPermissions(m.Author.ID, m.ChannelID)
+	}
+
+	return permissions, nil
+}
+
+// memberRoles are the roles of the author of the message, including the server's default role everyone has.
+func memberRoles(s *discordgo.Session, m *discordgo.MessageCreate) []string {
+	roles := []string{m.GuildID}
+
+	if m.Member != nil {
+		return append(roles, m.Member.Roles...)
+	}
+
+	member, err := s.State.Member(m.GuildID, m.Author.ID)
+	if err != nil {
+		if member, err = s.GuildMember(m.GuildID, m.Author.ID); err != nil {
+			handler.LogErrorMsg("Failed to look up roles", err)
+
+			return roles
+		}
+	}
+
+	return append(roles, member.Roles...)
+}
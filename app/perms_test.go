@@ -0,0 +1,118 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"quozlet.net/birbbot/app/commands/persistent"
+)
+
+func TestResolveOverride(t *testing.T) {
+	allow := func(kind, targetID string) permissionOverride {
+		return permissionOverride{kind: kind, targetID: targetID, allow: true}
+	}
+	deny := func(kind, targetID string) permissionOverride {
+		return permissionOverride{kind: kind, targetID: targetID}
+	}
+
+	// The guild's ID is its everyone role, which every member has
+	roles := []string{"guild", "mods", "birbs"}
+
+	tests := []struct {
+		name      string
+		overrides []permissionOverride
+		want      permissionOverride
+		found     bool
+	}{
+		{name: "no overrides", overrides: nil, found: false},
+		{
+			name:      "overrides for others",
+			overrides: []permissionOverride{deny(userTarget, "other"), deny(channelTarget, "elsewhere"), deny(roleTarget, "x")},
+			found:     false,
+		},
+		{
+			name:      "user beats channel",
+			overrides: []permissionOverride{deny(channelTarget, "here"), allow(userTarget, "me")},
+			want:      allow(userTarget, "me"),
+			found:     true,
+		},
+		{
+			name:      "user beats roles",
+			overrides: []permissionOverride{allow(roleTarget, "mods"), deny(userTarget, "me")},
+			want:      deny(userTarget, "me"),
+			found:     true,
+		},
+		{
+			name:      "channel beats roles",
+			overrides: []permissionOverride{deny(roleTarget, "mods"), allow(channelTarget, "here")},
+			want:      allow(channelTarget, "here"),
+			found:     true,
+		},
+		{
+			name:      "role",
+			overrides: []permissionOverride{allow(roleTarget, "birbs")},
+			want:      allow(roleTarget, "birbs"),
+			found:     true,
+		},
+		{
+			name:      "everyone",
+			overrides: []permissionOverride{deny(roleTarget, "guild")},
+			want:      deny(roleTarget, "guild"),
+			found:     true,
+		},
+		{
+			name:      "denying role wins",
+			overrides: []permissionOverride{allow(roleTarget, "mods"), deny(roleTarget, "guild"), allow(roleTarget, "birbs")},
+			want:      deny(roleTarget, "guild"),
+			found:     true,
+		},
+		{
+			name:      "first allowing role when none deny",
+			overrides: []permissionOverride{allow(roleTarget, "mods"), allow(roleTarget, "birbs")},
+			want:      allow(roleTarget, "mods"),
+			found:     true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			got, found := resolveOverride(test.overrides, "me", "here", roles)
+			if found != test.found || got != test.want {
+				t.Errorf("resolveOverride = %+v, %t, want %+v, %t", got, found, test.want, test.found)
+			}
+		})
+	}
+}
+
+func TestRequiredPermission(t *testing.T) {
+	var (
+		rss  Command = persistent.RSS{}
+		sub  Command = persistent.Sub{}
+		jobs Command = Jobs{}
+	)
+
+	tests := []struct {
+		name string
+		cmd  *Command
+		args []string
+		want int64
+	}{
+		{name: "adding a feed", cmd: &rss, args: []string{"https://a.example"}, want: discordgo.PermissionManageChannels},
+		{name: "removing a feed", cmd: &rss, args: []string{"remove", "1"}, want: discordgo.PermissionManageChannels},
+		{name: "listing feeds", cmd: &rss, args: []string{"list"}, want: 0},
+		{name: "latest items", cmd: &rss, args: []string{"latest", "1"}, want: 0},
+		{name: "no arguments", cmd: &rss, args: nil, want: discordgo.PermissionManageChannels},
+		{name: "always permissioned", cmd: &sub, args: []string{"list"}, want: discordgo.PermissionManageChannels},
+		{name: "not permissioned", cmd: &jobs, args: nil, want: 0},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			if got := requiredPermission(test.cmd, test.args); got != test.want {
+				t.Errorf("requiredPermission(%v) = %d, want %d", test.args, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"quozlet.net/birbbot/app/commands"
+)
+
+// bucketRate is how many commands a bucket allows in a burst, and how long it takes to allow another one.
+type bucketRate struct {
+	burst  int
+	refill time.Duration
+}
+
+// A user can't run commands faster than their channel, nor a channel faster than its server.
+var (
+	userRate    = bucketRate{burst: 5, refill: 3 * time.Second}
+	channelRate = bucketRate{burst: 10, refill: 2 * time.Second}
+	guildRate   = bucketRate{burst: 20, refill: time.Second}
+)
+
+// Buckets that have refilled (and cooldowns that have passed) are forgotten this often.
+const limiterSweepInterval = 10 * time.Minute
+
+const cooldownReaction = "⏳"
+
+// uncommandedReplies is what help, and replies to unrecognized commands, are throttled as.
+// It can't clash with a command, since it isn't a valid alias.
+const uncommandedReplies = "<reply>"
+
+// limiter throttles every command invocation.
+var limiter = newRateLimiter()
+
+// rateLimit is a bucket a command invocation takes a token from.
+type rateLimit struct {
+	key  string
+	rate bucketRate
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// refill adds the tokens regained since the bucket was last updated.
+func (b *tokenBucket) refill(rate bucketRate, now time.Time) {
+	regained := float64(now.Sub(b.updated)) / float64(rate.refill)
+	b.tokens = math.Min(float64(rate.burst), b.tokens+regained)
+	b.updated = now
+}
+
+// wait is how long until the bucket has a token to take.
+func (b *tokenBucket) wait(rate bucketRate) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) * float64(rate.refill))
+}
+
+// rateLimiter keeps a token bucket for each user, channel and server, and when users can next use each command.
+type rateLimiter struct {
+	mutex     sync.Mutex
+	buckets   map[string]*tokenBucket
+	cooldowns map[string]time.Time
+	swept     time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		buckets:   map[string]*tokenBucket{},
+		cooldowns: map[string]time.Time{},
+		swept:     time.Now(),
+	}
+}
+
+// take uses a token from every bucket, and starts the cooldown, returning 0.
+// If any bucket is empty (or the cooldown hasn't passed), nothing is taken and how long to wait is returned instead.
+func (l *rateLimiter) take(
+	limits []rateLimit,
+	cooldownKey string,
+	cooldown time.Duration,
+	now time.Time,
+) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.sweep(now)
+
+	wait := l.cooldowns[cooldownKey].Sub(now)
+
+	buckets := make([]*tokenBucket, len(limits))
+	for i, limit := range limits {
+		bucket, found := l.buckets[limit.key]
+		if !found {
+			bucket = &tokenBucket{tokens: float64(limit.rate.burst), updated: now}
+			l.buckets[limit.key] = bucket
+		}
+
+		bucket.refill(limit.rate, now)
+		buckets[i] = bucket
+
+		if bucketWait := bucket.wait(limit.rate); bucketWait > wait {
+			wait = bucketWait
+		}
+	}
+
+	if wait > 0 {
+		return wait
+	}
+
+	for _, bucket := range buckets {
+		bucket.tokens--
+	}
+
+	if cooldown > 0 {
+		l.cooldowns[cooldownKey] = now.Add(cooldown)
+	}
+
+	return 0
+}
+
+// sweep forgets the buckets that would be full again, and the cooldowns that have passed.
+// Every bucket refills within a sweep interval, so a forgotten bucket is recreated exactly as it would have been.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < limiterSweepInterval {
+		return
+	}
+
+	l.swept = now
+
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.updated) >= limiterSweepInterval {
+			delete(l.buckets, key)
+		}
+	}
+
+	for key, until := range l.cooldowns {
+		if !now.Before(until) {
+			delete(l.cooldowns, key)
+		}
+	}
+}
+
+// throttle reports how long the author of the message must wait before they can use the command, or 0 if they can.
+// A nil command is help or an unrecognized command, which are still replied to, so are throttled together.
+// Users who can manage the server are never throttled.
+func throttle(s *discordgo.Session, m *discordgo.MessageCreate, cmd *Command) time.Duration {
+	limits := []rateLimit{
+		{key: "user:" + m.Author.ID, rate: userRate},
+		{key: "channel:" + m.ChannelID, rate: channelRate},
+	}
+
+	if len(m.GuildID) != 0 {
+		if canManageServer(s, m) {
+			return 0
+		}
+
+		limits = append(limits, rateLimit{key: "guild:" + m.GuildID, rate: guildRate})
+	}
+
+	name := uncommandedReplies
+
+	var cooldown time.Duration
+
+	if cmd != nil {
+		name = commandName(cmd)
+
+		if cooldownCmd, hasCooldown := (*cmd).(CooldownCommand); hasCooldown {
+			cooldown = cooldownCmd.Cooldown()
+		}
+	}
+
+	return limiter.take(limits, m.Author.ID+":"+name, cooldown, time.Now())
+}
+
+// cooldownResponse lets the user know they were throttled, rather than ignoring them.
+// Slash commands have no message to react to, so they are told how long to wait instead.
+func cooldownResponse(m *discordgo.MessageCreate, wait time.Duration) commands.MessageResponse {
+	if len(m.ID) == 0 {
+		return commands.MessageResponse{
+			ChannelID: m.ChannelID,
+			Message:   fmt.Sprintf("Slow down! Try that again in %s", wait.Truncate(time.Second)+time.Second),
+		}
+	}
+
+	return commands.MessageResponse{
+		ChannelID: m.ChannelID,
+		Reaction: commands.ReactionResponse{
+			MessageID: m.ID,
+			Add:       cooldownReaction,
+		},
+	}
+}
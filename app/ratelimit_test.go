@@ -0,0 +1,155 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketRefill(t *testing.T) {
+	rate := bucketRate{burst: 5, refill: 2 * time.Second}
+	start := time.Unix(0, 0)
+
+	tests := []struct {
+		name    string
+		tokens  float64
+		elapsed time.Duration
+		want    float64
+	}{
+		{name: "no time passed", tokens: 1, elapsed: 0, want: 1},
+		{name: "one refill", tokens: 1, elapsed: 2 * time.Second, want: 2},
+		{name: "part of a refill", tokens: 0, elapsed: time.Second, want: 0.5},
+		{name: "capped at the burst", tokens: 4, elapsed: time.Minute, want: 5},
+		{name: "from empty to full", tokens: 0, elapsed: 10 * time.Second, want: 5},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			bucket := &tokenBucket{tokens: test.tokens, updated: start}
+			bucket.refill(rate, start.Add(test.elapsed))
+
+			if bucket.tokens != test.want {
+				t.Errorf("refill after %s = %v tokens, want %v", test.elapsed, bucket.tokens, test.want)
+			}
+
+			if !bucket.updated.Equal(start.Add(test.elapsed)) {
+				t.Errorf("refill left the bucket updated at %s", bucket.updated)
+			}
+		})
+	}
+}
+
+func TestTokenBucketWait(t *testing.T) {
+	rate := bucketRate{burst: 5, refill: 2 * time.Second}
+
+	tests := []struct {
+		name   string
+		tokens float64
+		want   time.Duration
+	}{
+		{name: "full", tokens: 5, want: 0},
+		{name: "exactly one token", tokens: 1, want: 0},
+		{name: "empty", tokens: 0, want: 2 * time.Second},
+		{name: "part of a token", tokens: 0.75, want: 500 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			bucket := &tokenBucket{tokens: test.tokens}
+			if got := bucket.wait(rate); got != test.want {
+				t.Errorf("wait with %v tokens = %s, want %s", test.tokens, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterTake(t *testing.T) {
+	start := time.Unix(0, 0)
+	user := rateLimit{key: "user:1", rate: bucketRate{burst: 2, refill: time.Second}}
+	channel := rateLimit{key: "channel:1", rate: bucketRate{burst: 3, refill: 2 * time.Second}}
+
+	type take struct {
+		at       time.Duration
+		cooldown time.Duration
+		want     time.Duration
+	}
+
+	tests := []struct {
+		name   string
+		limits []rateLimit
+		takes  []take
+	}{
+		{
+			name:   "burst then wait for a refill",
+			limits: []rateLimit{user},
+			takes: []take{
+				{at: 0, want: 0},
+				{at: 0, want: 0},
+				{at: 0, want: time.Second},
+				{at: 500 * time.Millisecond, want: 500 * time.Millisecond},
+				{at: time.Second, want: 0},
+			},
+		},
+		{
+			name:   "the slowest bucket decides",
+			limits: []rateLimit{user, channel},
+			takes: []take{
+				{at: 0, want: 0},
+				{at: 0, want: 0},
+				{at: 2 * time.Second, want: 0},
+				{at: 2 * time.Second, want: 0},
+				{at: 2 * time.Second, want: 2 * time.Second},
+			},
+		},
+		{
+			name:   "throttled invocations don't take tokens",
+			limits: []rateLimit{user},
+			takes: []take{
+				{at: 0, want: 0},
+				{at: 0, want: 0},
+				{at: 0, want: time.Second},
+				{at: 0, want: time.Second},
+				{at: time.Second, want: 0},
+			},
+		},
+		{
+			name:   "cooldown outlasts the buckets",
+			limits: []rateLimit{user},
+			takes: []take{
+				{at: 0, cooldown: 5 * time.Second, want: 0},
+				{at: time.Second, want: 4 * time.Second},
+				{at: 5 * time.Second, want: 0},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			limiter := newRateLimiter()
+			limiter.swept = start
+
+			for i, take := range test.takes {
+				if got := limiter.take(test.limits, "1:cmd", take.cooldown, start.Add(take.at)); got != take.want {
+					t.Errorf("take %d at %s = %s, want %s", i, take.at, got, take.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	start := time.Unix(0, 0)
+	limits := []rateLimit{{key: "user:1", rate: userRate}}
+
+	limiter := newRateLimiter()
+	limiter.swept = start
+	limiter.take(limits, "1:cmd", time.Minute, start)
+
+	limiter.sweep(start.Add(limiterSweepInterval))
+
+	if len(limiter.buckets) != 0 || len(limiter.cooldowns) != 0 {
+		t.Errorf("sweep kept %d buckets and %d cooldowns, want none", len(limiter.buckets), len(limiter.cooldowns))
+	}
+}
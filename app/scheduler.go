@@ -0,0 +1,216 @@
+package app
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"quozlet.net/birbbot/app/commands"
+	"quozlet.net/birbbot/app/commands/recurring"
+)
+
+const (
+	// Unless a RecurringCommand is a TimedCommand, it is cancelled after this long.
+	defaultRecurringTimeout = 10 * time.Minute
+	// Runs are delayed by a random amount up to this long (or a tenth of the gap between runs, if that's shorter),
+	// so that jobs sharing a schedule don't all hit the database and network at once.
+	maxJitter = time.Minute
+)
+
+// Scheduler runs each RecurringCommand on its own Schedule.
+// A job never overlaps with itself: if a run takes longer than the gap to its next run, the missed runs are skipped.
+type Scheduler struct {
+	mutex sync.RWMutex
+	jobs  map[string]*job
+}
+
+type job struct {
+	name     string
+	cmd      *RecurringCommand
+	schedule recurring.Schedule
+	next     time.Time
+	running  bool
+}
+
+// JobStatus is a point-in-time view of a scheduled job.
+type JobStatus struct {
+	Name    string
+	Next    time.Time
+	Running bool
+}
+
+func newScheduler() *Scheduler {
+	return &Scheduler{jobs: map[string]*job{}}
+}
+
+// add a RecurringCommand as a job, named after its type.
+func (s *Scheduler) add(cmd *RecurringCommand) {
+	name := reflect.TypeOf(*cmd).Name()
+
+	s.mutex.Lock()
+	s.jobs[name] = &job{name: name, cmd: cmd, schedule: (*cmd).Schedule()}
+	s.mutex.Unlock()
+
+	log.Printf("Registered recurring command: %s", name)
+}
+
+// start running every job, until the context is cancelled.
+func (s *Scheduler) start(ctx context.Context, dbPool *pgxpool.Pool, session *discordgo.Session) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, j := range s.jobs {
+		go s.run(ctx, j, dbPool, session)
+	}
+
+	log.Printf("Scheduled %d recurring commands, monitoring...", len(s.jobs))
+}
+
+// NextRun reports when a job will next run, and false if there is no such job or it won't run again.
+func (s *Scheduler) NextRun(name string) (time.Time, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	j, found := s.jobs[name]
+	if !found || j.next.IsZero() {
+		return time.Time{}, false
+	}
+
+	return j.next, true
+}
+
+// Jobs returns the status of every job, sorted by name.
+func (s *Scheduler) Jobs() []JobStatus {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	statuses := make([]JobStatus, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		statuses = append(statuses, JobStatus{Name: j.name, Next: j.next, Running: j.running})
+	}
+
+	sort.Slice(statuses, func(a, b int) bool {
+		return statuses[a].Name < statuses[b].Name
+	})
+
+	return statuses
+}
+
+func (s *Scheduler) run(ctx context.Context, j *job, dbPool *pgxpool.Pool, session *discordgo.Session) {
+	for {
+		// The next run is always computed from now, so runs missed while the job was running are skipped
+		now := time.Now()
+		next := j.schedule.Next(now)
+
+		if next.IsZero() {
+			log.Printf("%s has no more runs scheduled", j.name)
+			s.setNext(j, time.Time{})
+
+			return
+		}
+
+		next = next.Add(jitter(next.Sub(now)))
+		s.setNext(j, next)
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Printf("Stopped scheduling %s", j.name)
+
+			return
+		case <-timer.C:
+		}
+
+		s.setRunning(j, true)
+		log.Printf("%s check ran", j.name)
+		processRecurringMsg(ctx, j.cmd, dbPool, session)
+		s.setRunning(j, false)
+	}
+}
+
+func (s *Scheduler) setNext(j *job, next time.Time) {
+	s.mutex.Lock()
+	j.next = next
+	s.mutex.Unlock()
+}
+
+func (s *Scheduler) setRunning(j *job, running bool) {
+	s.mutex.Lock()
+	j.running = running
+	s.mutex.Unlock()
+}
+
+// jitter returns a random delay proportionate to the gap between runs.
+func jitter(gap time.Duration) time.Duration {
+	limit := gap / 10
+	if limit > maxJitter {
+		limit = maxJitter
+	}
+
+	if limit <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(limit)))
+}
+
+func processRecurringMsg(
+	ctx context.Context,
+	cmd *RecurringCommand,
+	dbPool *pgxpool.Pool,
+	session *discordgo.Session,
+) {
+	// Recurring commands aren't started while shutting down
+	if !inFlight.begin() {
+		return
+	}
+	defer inFlight.end()
+
+	started := time.Now()
+	pendingMsgs, err := safelyCheck(ctx, cmd, dbPool)
+	sent := 0
+
+	for _, msg := range pendingMsgs {
+		log.Printf("%s -> %#v", msg.ChannelID, msg.Message)
+
+		if sendResponse(session, msg) {
+			sent++
+		}
+	}
+
+	recordRun(dbPool, reflect.TypeOf(*cmd).Name(), started, sent, err)
+}
+
+// safelyCheck runs a RecurringCommand, recovering from a panic so it is still scheduled to run again.
+// If the command ran out of time, that is reported as the error.
+func safelyCheck(
+	ctx context.Context,
+	cmd *RecurringCommand,
+	dbPool *pgxpool.Pool,
+) (pendingMsgs []commands.MessageResponse, err error) {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout(*cmd, defaultRecurringTimeout))
+	defer cancel()
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = commands.RecoverError(recovered)
+			log.Printf("%s failed: %s", reflect.TypeOf(*cmd).Name(), err)
+
+			pendingMsgs = nil
+		}
+	}()
+
+	pendingMsgs, err = (*cmd).Check(ctx, dbPool)
+	if err == nil {
+		err = ctx.Err()
+	}
+
+	return pendingMsgs, err
+}
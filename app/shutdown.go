@@ -0,0 +1,100 @@
+package app
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	handler "quozlet.net/birbbot/util"
+)
+
+// Once cancelled, commands should return quickly, but they are only waited on for this long.
+const cancelGracePeriod = 5 * time.Second
+
+var inFlight = &workTracker{}
+
+// Bot is a connected Discord session, and everything that must be stopped before disconnecting it.
+type Bot struct {
+	Session      *discordgo.Session
+	cancel       context.CancelFunc
+	stopAudio    chan struct{}
+	audioStopped chan struct{}
+}
+
+// Shutdown stops accepting commands, and waits (up to the timeout) for commands in progress to finish.
+// Anything still running after that is cancelled. Then audio is stopped and the session is closed.
+func (b *Bot) Shutdown(timeout time.Duration) {
+	log.Println("Shutting down, no longer accepting commands")
+	inFlight.stop()
+
+	if !inFlight.wait(timeout) {
+		log.Printf("Commands still running after %s, cancelling them", timeout)
+	}
+
+	b.cancel()
+
+	if !inFlight.wait(cancelGracePeriod) {
+		log.Println("Commands still running after being cancelled, abandoning them")
+	}
+
+	close(b.stopAudio)
+	select {
+	case <-b.audioStopped:
+	case <-time.After(cancelGracePeriod):
+		log.Println("Audio didn't stop in time, abandoning it")
+	}
+
+	handler.LogErrorMsg("Failed to close the Discord session", b.Session.Close())
+	log.Println("Shut down")
+}
+
+// workTracker keeps count of commands in progress, so they can be waited on.
+type workTracker struct {
+	mutex    sync.Mutex
+	stopping bool
+	running  sync.WaitGroup
+}
+
+// begin reports whether new work can be started, and if so it must be followed by a call to end.
+func (w *workTracker) begin() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.stopping {
+		return false
+	}
+
+	w.running.Add(1)
+
+	return true
+}
+
+func (w *workTracker) end() {
+	w.running.Done()
+}
+
+// stop prevents any new work from beginning.
+func (w *workTracker) stop() {
+	w.mutex.Lock()
+	w.stopping = true
+	w.mutex.Unlock()
+}
+
+// wait reports whether all work ended within the timeout.
+func (w *workTracker) wait(timeout time.Duration) bool {
+	ended := make(chan struct{})
+
+	go func() {
+		w.running.Wait()
+		close(ended)
+	}()
+
+	select {
+	case <-ended:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
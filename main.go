@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"math/rand"
@@ -10,13 +11,23 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	// Digests are posted at a local time, and the image has no time zone database
+	_ "time/tzdata"
 
 	"quozlet.net/birbbot/app"
+	"quozlet.net/birbbot/app/migrations"
 
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
+// How long commands in progress have to finish once the process is killed, before they're cancelled.
+const shutdownTimeout = 30 * time.Second
+
 func main() {
+	migrateOnly := flag.Bool("migrate", false, "apply database migrations, then exit without connecting to Discord")
+	rollback := flag.Int("rollback", -1, "revert database migrations down to this version, then exit")
+	flag.Parse()
+
 	rand.Seed(time.Now().Unix())
 
 	dbPool, dbErr := pgxpool.Connect(context.Background(), fmt.Sprintf("postgres://%s:%s@%s:%s/%s",
@@ -32,31 +43,27 @@ func main() {
 	}
 	defer dbPool.Close()
 
-	ticker := app.Timers{
-		Daily:           time.NewTicker(time.Hour * 24),
-		Hourly:          time.NewTicker(time.Hour),
-		Minutely:        time.NewTicker(time.Minute),
-		FiveMinutely:    time.NewTicker(time.Minute * 5),
-		TenMinutely:     time.NewTicker(time.Minute * 10),
-		QuarterHourly:   time.NewTicker(time.Minute * 15),
-		HalfHourly:      time.NewTicker(time.Minute * 30),
-		QuarterToHourly: time.NewTicker(time.Minute * 45),
+	if *rollback >= 0 {
+		if err := migrations.Down(context.Background(), dbPool, *rollback); err != nil {
+			log.Println(err)
+		}
+
+		return
 	}
+	// Commands expect the schema to be up to date when they're discovered
+	if err := migrations.Up(context.Background(), dbPool); err != nil {
+		log.Println(err)
 
-	defer ticker.StopAll()
-	session, err := app.Start(os.Getenv("DISCORD_SECRET"), dbPool, &ticker)
+		return
+	}
 
-	defer func() {
-		// If a session is established, close it properly before exiting
-		if session != nil {
-			if sessionErr := session.Close(); sessionErr != nil {
-				log.Fatal(sessionErr)
+	if *migrateOnly {
+		log.Printf("Database is at version %d", migrations.Latest())
 
-				return
-			}
-		}
-	}()
+		return
+	}
 
+	bot, err := app.Start(context.Background(), os.Getenv("DISCORD_SECRET"), dbPool)
 	if err != nil {
 		log.Println(err)
 
@@ -68,4 +75,6 @@ func main() {
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
 	<-sig
+	// The database is only closed once the bot has shut down, since commands in progress may still be using it
+	bot.Shutdown(shutdownTimeout)
 }